@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var ErrTooLarge = errors.New("cache: entry too large")
+
+type SizeError struct {
+	Key   string
+	What  string
+	Size  int
+	Limit int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("cache: %s of %.64q is %d bytes, limit is %d", e.What, e.Key, e.Size, e.Limit)
+}
+
+func (e *SizeError) Unwrap() error {
+	return ErrTooLarge
+}
+
+type Decision string
+
+const (
+	// DecisionAccept stores the item in the wrapped cache.
+	DecisionAccept Decision = "ACCEPT"
+	// DecisionReject fails the write with a *SizeError.
+	DecisionReject Decision = "REJECT"
+	// DecisionDrop logs the item and skips storing it. GetOrSet still returns
+	// the created value.
+	DecisionDrop Decision = "DROP"
+	// DecisionRoute stores the item in AdmissionConfig.Overflow instead.
+	DecisionRoute Decision = "ROUTE"
+)
+
+type AdmissionConfig struct {
+	MaxKeyLength int
+	MaxValueSize int
+	// Admit decides what happens to each write given its encoded size and
+	// whether it exceeds MaxValueSize. By default oversized values are
+	// rejected and everything else is accepted.
+	Admit func(key string, size int, oversized bool) Decision
+	// Overflow receives the items routed away from the wrapped cache, for
+	// example a Disk cache for values too large for Redis.
+	Overflow Cache
+}
+
+// Admission guards a cache against oversized keys and values.
+func NewAdmission(c Cache, cfg *AdmissionConfig) *Admission {
+	if cfg == nil {
+		cfg = &AdmissionConfig{}
+	}
+	return &Admission{Cache: c, Config: cfg}
+}
+
+type Admission struct {
+	Cache
+	Config *AdmissionConfig
+}
+
+type admitted struct {
+	decision Decision
+	body     json.RawMessage
+	duration time.Duration
+}
+
+var errNotAdmitted = errors.New("cache: not admitted")
+
+func (c *Admission) checkKey(key string) error {
+	if c.Config.MaxKeyLength > 0 && len(key) > c.Config.MaxKeyLength {
+		return &SizeError{Key: key, What: "key", Size: len(key), Limit: c.Config.MaxKeyLength}
+	}
+	return nil
+}
+
+// admit wraps create so that only accepted items reach the wrapped cache.
+// Anything else aborts the write with errNotAdmitted and is handled by
+// settle once the wrapped cache has returned.
+func (c *Admission) admit(key string, create func() (*Item, error), a *admitted) func() (*Item, error) {
+	return func() (*Item, error) {
+		item, err := create()
+		if err != nil {
+			return nil, err
+		}
+		body, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		oversized := c.Config.MaxValueSize > 0 && len(body) > c.Config.MaxValueSize
+		a.decision = DecisionAccept
+		if c.Config.Admit != nil {
+			a.decision = c.Config.Admit(key, len(body), oversized)
+		} else if oversized {
+			a.decision = DecisionReject
+		}
+		if a.decision == DecisionRoute && c.Config.Overflow == nil {
+			a.decision = DecisionReject
+		}
+		a.body, a.duration = body, item.Duration
+		if a.decision != DecisionAccept {
+			return nil, errNotAdmitted
+		}
+		return &Item{Value: a.body, Duration: item.Duration}, nil
+	}
+}
+
+func (c *Admission) settle(key string, a *admitted, err error) error {
+	if err != errNotAdmitted {
+		return err
+	}
+	switch a.decision {
+	case DecisionDrop:
+		log.Printf("cache admission: dropped %.64q, %d bytes: %.128s", key, len(a.body), a.body)
+		return nil
+	case DecisionRoute:
+		return c.Config.Overflow.Set(key, func() (*Item, error) {
+			return &Item{Value: a.body, Duration: a.duration}, nil
+		})
+	}
+	return &SizeError{Key: key, What: "value", Size: len(a.body), Limit: c.Config.MaxValueSize}
+}
+
+func (c *Admission) Keys(prefix string) ([]string, error) {
+	return listKeys(c.Cache, prefix)
+}
+
+func (c *Admission) Get(key string, result interface{}) error {
+	err := c.Cache.Get(key, result)
+	if err != nil && c.Config.Overflow != nil {
+		if c.Config.Overflow.Get(key, result) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *Admission) Set(key string, create func() (*Item, error)) error {
+	if err := c.checkKey(key); err != nil {
+		return err
+	}
+	var a admitted
+	err := c.settle(key, &a, c.Cache.Set(key, c.admit(key, create, &a)))
+	if err != nil || c.Config.Overflow == nil {
+		return err
+	}
+	// drop the copy left on the other side by an earlier write
+	switch a.decision {
+	case DecisionAccept:
+		c.Config.Overflow.Remove(key)
+	case DecisionRoute:
+		c.Cache.Remove(key)
+	}
+	return nil
+}
+
+func (c *Admission) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	if err := c.checkKey(key); err != nil {
+		return err
+	}
+	if c.Config.Overflow != nil && c.Get(key, result) == nil {
+		return nil
+	}
+	var a admitted
+	err := c.Cache.GetOrSet(key, result, c.admit(key, create, &a))
+	if err != errNotAdmitted {
+		return err
+	}
+	if err := c.settle(key, &a, err); err != nil {
+		return err
+	}
+	return json.Unmarshal(a.body, result)
+}
+
+func (c *Admission) Remove(key string) {
+	c.Cache.Remove(key)
+	if c.Config.Overflow != nil {
+		c.Config.Overflow.Remove(key)
+	}
+}
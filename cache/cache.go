@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -12,6 +13,19 @@ type Cache interface {
 	Remove(key string)
 }
 
+// KeyLister is implemented by caches that can enumerate their live keys.
+type KeyLister interface {
+	Keys(prefix string) ([]string, error)
+}
+
+func listKeys(c Cache, prefix string) ([]string, error) {
+	lister, ok := c.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("cache %T can't list keys", c)
+	}
+	return lister.Keys(prefix)
+}
+
 type Item struct {
 	Value    interface{}
 	Duration time.Duration
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrChaos        = errors.New("cache: injected failure")
+	ErrChaosTimeout = errors.New("cache: injected timeout")
+)
+
+type Op string
+
+const (
+	OpAll      Op = "*"
+	OpLockRun  Op = "LockRun"
+	OpGet      Op = "Get"
+	OpSet      Op = "Set"
+	OpGetOrSet Op = "GetOrSet"
+	OpRemove   Op = "Remove"
+)
+
+type ChaosRule struct {
+	// ErrorRate is the probability in [0, 1] that the operation fails with
+	// Err instead of reaching the wrapped cache.
+	ErrorRate float64
+	Err       error
+	// Latency delays the operation. It is ignored when LatencyFunc is set.
+	Latency time.Duration
+	Jitter  time.Duration
+	// LatencyFunc draws the delay from a custom distribution, see
+	// UniformLatency and NormalLatency.
+	LatencyFunc func() time.Duration
+	// Timeout caps the delay; an operation delayed past it fails with
+	// ErrChaosTimeout after waiting Timeout.
+	Timeout time.Duration
+}
+
+func UniformLatency(min, max time.Duration) func() time.Duration {
+	return func() time.Duration {
+		return min + time.Duration(rand.Int63n(int64(max-min)+1))
+	}
+}
+
+func NormalLatency(mean, stddev time.Duration) func() time.Duration {
+	return func() time.Duration {
+		d := time.Duration(rand.NormFloat64()*float64(stddev)) + mean
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// Chaos injects failures and latency into a cache so fallback paths can be
+// exercised in tests. Rules can be changed while it is in use; OpAll applies
+// to every operation that has no rule of its own.
+func NewChaos(c Cache) *Chaos {
+	return &Chaos{Cache: c, rules: make(map[Op]ChaosRule), enabled: true}
+}
+
+type Chaos struct {
+	Cache
+
+	mu      sync.RWMutex
+	rules   map[Op]ChaosRule
+	enabled bool
+}
+
+func (c *Chaos) SetRule(op Op, rule ChaosRule) *Chaos {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules[op] = rule
+	return c
+}
+
+func (c *Chaos) ClearRule(op Op) *Chaos {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rules, op)
+	return c
+}
+
+func (c *Chaos) Enable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = true
+}
+
+func (c *Chaos) Disable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = false
+}
+
+func (c *Chaos) Enabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.enabled
+}
+
+func (c *Chaos) inject(op Op) error {
+	c.mu.RLock()
+	rule, found := c.rules[op]
+	if !found {
+		rule, found = c.rules[OpAll]
+	}
+	enabled := c.enabled
+	c.mu.RUnlock()
+	if !enabled || !found {
+		return nil
+	}
+
+	d := rule.Latency
+	if rule.LatencyFunc != nil {
+		d = rule.LatencyFunc()
+	} else if rule.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(rule.Jitter)))
+	}
+	if rule.Timeout > 0 && d >= rule.Timeout {
+		time.Sleep(rule.Timeout)
+		return ErrChaosTimeout
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	if rule.ErrorRate > 0 && rand.Float64() < rule.ErrorRate {
+		if rule.Err != nil {
+			return rule.Err
+		}
+		return ErrChaos
+	}
+	return nil
+}
+
+func (c *Chaos) Keys(prefix string) ([]string, error) {
+	return listKeys(c.Cache, prefix)
+}
+
+func (c *Chaos) LockRun(key string, d time.Duration, fn func() error) error {
+	if err := c.inject(OpLockRun); err != nil {
+		return err
+	}
+	return c.Cache.LockRun(key, d, fn)
+}
+
+func (c *Chaos) Get(key string, result interface{}) error {
+	if err := c.inject(OpGet); err != nil {
+		return err
+	}
+	return c.Cache.Get(key, result)
+}
+
+func (c *Chaos) Set(key string, create func() (*Item, error)) error {
+	if err := c.inject(OpSet); err != nil {
+		return err
+	}
+	return c.Cache.Set(key, create)
+}
+
+func (c *Chaos) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	if err := c.inject(OpGetOrSet); err != nil {
+		return err
+	}
+	return c.Cache.GetOrSet(key, result, create)
+}
+
+// Remove has no error to report, so an injected failure silently skips the
+// removal, as a lost delete would.
+func (c *Chaos) Remove(key string) {
+	if err := c.inject(OpRemove); err != nil {
+		return
+	}
+	c.Cache.Remove(key)
+}
@@ -0,0 +1,57 @@
+package cache
+
+import "time"
+
+// Clock is the time source of the in-process caches. Tests can swap in a
+// fake clock (see cachetest.FakeClock) to drive expiry and the gc, save and
+// load loops without sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+type options struct {
+	clock Clock
+}
+
+type Option func(*options)
+
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{clock: SystemClock}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
 	"strconv"
@@ -12,10 +13,11 @@ import (
 
 var tableName = "table_" + strconv.FormatInt(time.Now().UnixNano(), 10)
 
-func NewDB(db *gorm.DB, tableName string) *DB {
+func NewDB(db *gorm.DB, tableName string, opts ...Option) *DB {
+	o := newOptions(opts)
 	c := &DB{
-		Memory:     NewMemory(),
-		loadTicker: time.NewTicker(time.Minute * 30),
+		Memory:     NewMemory(opts...),
+		loadTicker: o.clock.NewTicker(time.Minute * 30),
 		loadStop:   make(chan bool),
 		db:         db,
 		tableName:  tableName,
@@ -43,7 +45,7 @@ func (m *dbItem) TableName() string {
 type DB struct {
 	*Memory
 
-	loadTicker *time.Ticker
+	loadTicker Ticker
 	loadStop   chan bool
 	db         *gorm.DB
 	tableName  string
@@ -59,7 +61,7 @@ func (c *DB) initTable() {
 func (c *DB) loadLoop() {
 	for {
 		select {
-		case <-c.loadTicker.C:
+		case <-c.loadTicker.C():
 			if err := c.load(); err != nil {
 				log.Println("db cache load:", err)
 			}
@@ -71,26 +73,48 @@ func (c *DB) loadLoop() {
 }
 
 func (c *DB) load() (err error) {
-	if err := c.db.Table(c.tableName).Where("`expiration` != 0 AND `expiration` < ?", time.Now().UnixNano()).Delete(dbItem{}).Error; err != nil {
+	if err := c.db.Table(c.tableName).Where("`expiration` != 0 AND `expiration` < ?", c.clock.Now().UnixNano()).Delete(dbItem{}).Error; err != nil {
 		log.Println(err)
 	}
 	var rows []dbItem
 	if err := c.db.Table(c.tableName).
-		Where("`value` IS NOT NULL AND `expiration` = 0 OR `expiration` >= ?", time.Now().UnixNano()).
+		Where("`value` IS NOT NULL AND `expiration` = 0 OR `expiration` >= ?", c.clock.Now().UnixNano()).
 		Find(&rows).Error; err != nil {
 		return err
 	}
 
+	now := c.clock.Now()
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	old := c.Storage
 	c.Storage = make(map[string]memoryItem)
 
 	for _, row := range rows {
-		c.Storage[row.Key] = memoryItem{Body: *row.Value, Expiration: row.Expiration}
+		mem := memoryItem{Body: *row.Value, Expiration: row.Expiration}
+		c.Storage[row.Key] = mem
+		// the table is shared with other instances, so diff against what
+		// was loaded before to report their writes to watchers
+		if prev, found := old[row.Key]; !found || prev.Expiration != mem.Expiration || !bytes.Equal(prev.Body, mem.Body) {
+			c.watchers.notify(EventSet, row.Key, now)
+		}
+	}
+	for k, prev := range old {
+		if _, found := c.Storage[k]; found {
+			continue
+		}
+		if prev.Expired(now.UnixNano()) {
+			c.watchers.notify(EventExpire, k, now)
+		} else {
+			c.watchers.notify(EventRemove, k, now)
+		}
 	}
 	return nil
 }
 
+func (c *DB) ResetLoad(d time.Duration) {
+	c.loadTicker.Reset(d)
+}
+
 func (c *DB) save(key string, mem memoryItem) error {
 	body := json.RawMessage(mem.Body)
 	row := dbItem{
@@ -128,7 +152,7 @@ func (c *DB) GetOrSet(key string, result interface{}, create func() (*Item, erro
 	defer mu.Unlock()
 
 	entry, found := c.Storage[key]
-	if found && !entry.Expired(time.Now().UnixNano()) {
+	if found && !entry.Expired(c.clock.Now().UnixNano()) {
 		return json.Unmarshal(entry.Body, &result)
 	}
 
@@ -142,7 +166,7 @@ func (c *DB) GetOrSet(key string, result interface{}, create func() (*Item, erro
 	}
 	mem := memoryItem{Body: body}
 	if item.Duration != 0 {
-		mem.Expiration = time.Now().Add(item.Duration).UnixNano()
+		mem.Expiration = c.clock.Now().Add(item.Duration).UnixNano()
 	}
 
 	go c.save(key, mem)
@@ -150,6 +174,7 @@ func (c *DB) GetOrSet(key string, result interface{}, create func() (*Item, erro
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.Storage[key] = mem
+	c.watchers.notify(EventSet, key, c.clock.Now())
 
 	return json.Unmarshal(body, &result)
 }
@@ -0,0 +1,466 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Disk is an append-only log of records with an in-memory index of key to
+// value offset, so only keys live in RAM and each read or write touches a
+// single record. Removed, overwritten and expired records are dropped by
+// compaction, which rewrites the live records into a fresh log.
+//
+// Record layout (little endian):
+//
+//	crc32 | flag | expiration int64 | key len uint32 | value len uint32 | key | value
+//
+// The checksum covers everything after itself. A truncated or corrupt tail,
+// as left by a crash mid-write, is cut off when the log is reopened.
+func NewDisk(fp string, opts ...Option) (*Disk, error) {
+	o := newOptions(opts)
+	c := &Disk{
+		clock:         o.clock,
+		fp:            fp,
+		index:         make(map[string]diskEntry),
+		nx:            make(map[string]int64),
+		mus:           make(map[string]*sync.RWMutex),
+		CompactRatio:  0.5,
+		CompactMin:    1 << 20,
+		compactTicker: o.clock.NewTicker(time.Minute * 10),
+		compactStop:   make(chan bool),
+	}
+	dir := path.Dir(c.fp)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		os.MkdirAll(dir, 0777)
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	go c.compactLoop()
+	return c, nil
+}
+
+const (
+	diskHeaderSize = 4 + 1 + 8 + 4 + 4
+
+	diskFlagPut    byte = 0
+	diskFlagDelete byte = 1
+)
+
+var ErrDiskMiss = errors.New("disk: cache miss")
+
+type diskEntry struct {
+	Offset     int64
+	KeySize    uint32
+	ValueSize  uint32
+	Expiration int64
+}
+
+func (e diskEntry) size() int64 {
+	return diskHeaderSize + int64(e.KeySize) + int64(e.ValueSize)
+}
+
+func (e diskEntry) Expired(unixNano int64) bool {
+	return memoryItem{Expiration: e.Expiration}.Expired(unixNano)
+}
+
+type Disk struct {
+	// Sync flushes every write to stable storage before returning.
+	Sync bool
+	// CompactRatio is the share of dead bytes in the log that triggers a
+	// compaction, once the log holds at least CompactMin dead bytes.
+	CompactRatio float64
+	CompactMin   int64
+
+	clock         Clock
+	watchers      watchers
+	fp            string
+	f             *os.File
+	size          int64
+	dead          int64
+	index         map[string]diskEntry
+	mu            sync.RWMutex
+	mus           map[string]*sync.RWMutex
+	nx            map[string]int64
+	compactTicker Ticker
+	compactStop   chan bool
+}
+
+func (c *Disk) open() error {
+	f, err := os.OpenFile(c.fp, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	index, size, dead, err := readDiskIndex(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	c.f, c.index, c.size, c.dead = f, index, size, dead
+	return nil
+}
+
+func readDiskIndex(f *os.File) (map[string]diskEntry, int64, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	index := make(map[string]diskEntry)
+	r := bufio.NewReader(f)
+	header := make([]byte, diskHeaderSize)
+	var offset, dead int64
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF {
+				log.Println("disk cache: truncating torn record at", offset)
+			}
+			return index, offset, dead, nil
+		}
+		flag := header[4]
+		entry := diskEntry{
+			Offset:     offset,
+			Expiration: int64(binary.LittleEndian.Uint64(header[5:])),
+			KeySize:    binary.LittleEndian.Uint32(header[13:]),
+			ValueSize:  binary.LittleEndian.Uint32(header[17:]),
+		}
+		if offset+entry.size() > info.Size() {
+			log.Println("disk cache: truncating torn record at", offset)
+			return index, offset, dead, nil
+		}
+		body := make([]byte, int(entry.KeySize)+int(entry.ValueSize))
+		if _, err := io.ReadFull(r, body); err != nil {
+			log.Println("disk cache: truncating torn record at", offset)
+			return index, offset, dead, nil
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header) {
+			log.Println("disk cache: truncating corrupt record at", offset)
+			return index, offset, dead, nil
+		}
+
+		key := string(body[:entry.KeySize])
+		if old, found := index[key]; found {
+			dead += old.size()
+			delete(index, key)
+		}
+		if flag == diskFlagDelete {
+			dead += entry.size()
+		} else {
+			index[key] = entry
+		}
+		offset += entry.size()
+	}
+}
+
+func encodeDiskRecord(flag byte, key string, value []byte, expiration int64) []byte {
+	buf := make([]byte, diskHeaderSize+len(key)+len(value))
+	buf[4] = flag
+	binary.LittleEndian.PutUint64(buf[5:], uint64(expiration))
+	binary.LittleEndian.PutUint32(buf[13:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[17:], uint32(len(value)))
+	copy(buf[diskHeaderSize:], key)
+	copy(buf[diskHeaderSize+len(key):], value)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// append writes a record at the end of the log. The caller holds c.mu.
+func (c *Disk) append(flag byte, key string, value []byte, expiration int64) (diskEntry, error) {
+	buf := encodeDiskRecord(flag, key, value, expiration)
+	if _, err := c.f.WriteAt(buf, c.size); err != nil {
+		return diskEntry{}, err
+	}
+	if c.Sync {
+		if err := c.f.Sync(); err != nil {
+			return diskEntry{}, err
+		}
+	}
+	entry := diskEntry{
+		Offset:     c.size,
+		KeySize:    uint32(len(key)),
+		ValueSize:  uint32(len(value)),
+		Expiration: expiration,
+	}
+	c.size += entry.size()
+	return entry, nil
+}
+
+func (c *Disk) read(key string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, found := c.index[key]
+	if !found || entry.Expired(c.clock.Now().UnixNano()) {
+		return nil, ErrDiskMiss
+	}
+	value := make([]byte, entry.ValueSize)
+	if _, err := c.f.ReadAt(value, entry.Offset+diskHeaderSize+int64(entry.KeySize)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (c *Disk) write(key string, value []byte, expiration int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, err := c.append(diskFlagPut, key, value, expiration)
+	if err != nil {
+		return err
+	}
+	if old, found := c.index[key]; found {
+		c.dead += old.size()
+	}
+	c.index[key] = entry
+	c.watchers.notify(EventSet, key, c.clock.Now())
+	return nil
+}
+
+func (c *Disk) compactLoop() {
+	for {
+		select {
+		case <-c.compactTicker.C():
+			c.ClearExpired()
+			if err := c.compactIfNeeded(); err != nil {
+				log.Println("disk cache compact:", err)
+			}
+		case <-c.compactStop:
+			c.compactTicker.Stop()
+			return
+		}
+	}
+}
+
+func (c *Disk) gcRWMutex(key string) *sync.RWMutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mus[key] == nil {
+		c.mus[key] = &sync.RWMutex{}
+	}
+	return c.mus[key]
+}
+
+func (c *Disk) ResetCompact(d time.Duration) {
+	c.compactTicker.Reset(d)
+}
+
+func (c *Disk) StopCompact() {
+	c.compactStop <- true
+}
+
+func (c *Disk) Close() error {
+	c.StopCompact()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.f.Close()
+}
+
+// ClearExpired drops expired keys from the index. Their records become dead
+// bytes and are reclaimed by the next compaction.
+func (c *Disk) ClearExpired() {
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.index {
+		if v.Expired(now.UnixNano()) {
+			delete(c.index, k)
+			c.dead += v.size()
+			c.watchers.notify(EventExpire, k, now)
+		}
+	}
+}
+
+func (c *Disk) Watch(pattern string) (<-chan Event, func()) {
+	return c.watchers.add(pattern)
+}
+
+func (c *Disk) compactIfNeeded() error {
+	c.mu.RLock()
+	need := c.dead >= c.CompactMin && float64(c.dead) >= float64(c.size)*c.CompactRatio
+	c.mu.RUnlock()
+	if !need {
+		return nil
+	}
+	return c.Compact()
+}
+
+// Compact rewrites the live, unexpired records into a new log and swaps it in.
+func (c *Disk) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp := c.fp + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	now := c.clock.Now().UnixNano()
+	index := make(map[string]diskEntry, len(c.index))
+	var offset int64
+	for key, entry := range c.index {
+		if entry.Expired(now) {
+			continue
+		}
+		buf := make([]byte, entry.size())
+		if _, err := c.f.ReadAt(buf, entry.Offset); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		entry.Offset = offset
+		index[key] = entry
+		offset += entry.size()
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.fp); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	c.f.Close()
+	c.f, c.index, c.size, c.dead = f, index, offset, 0
+	return nil
+}
+
+func (c *Disk) Keys(prefix string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.clock.Now().UnixNano()
+	keys := make([]string, 0)
+	for k, v := range c.index {
+		if strings.HasPrefix(k, prefix) && !v.Expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (c *Disk) LockRun(key string, d time.Duration, fn func() error) error {
+	c.mu.Lock()
+	now := c.clock.Now().UnixNano()
+	if c.nx[key] != 0 && c.nx[key]+int64(d) > now {
+		c.mu.Unlock()
+		return fmt.Errorf("the system is busy. please try again later. key:%s", key)
+	}
+	c.nx[key] = now
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.nx[key] == now {
+			delete(c.nx, key)
+		}
+	}()
+	return fn()
+}
+
+func (c *Disk) Get(key string, result interface{}) error {
+	body, err := c.read(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, result)
+}
+
+func (c *Disk) Set(key string, create func() (*Item, error)) error {
+	mu := c.gcRWMutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	item, err := create()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(item.Value)
+	if err != nil {
+		return err
+	}
+	var expiration int64
+	if item.Duration != 0 {
+		expiration = c.clock.Now().Add(item.Duration).UnixNano()
+	}
+	return c.write(key, body, expiration)
+}
+
+func (c *Disk) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	mu := c.gcRWMutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	body, err := c.read(key)
+	if err == nil {
+		return json.Unmarshal(body, result)
+	}
+	if err != ErrDiskMiss {
+		return err
+	}
+
+	item, err := create()
+	if err != nil {
+		return err
+	}
+	body, err = json.Marshal(item.Value)
+	if err != nil {
+		return err
+	}
+	var expiration int64
+	if item.Duration != 0 {
+		expiration = c.clock.Now().Add(item.Duration).UnixNano()
+	}
+	if err := c.write(key, body, expiration); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+func (c *Disk) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, found := c.index[key]
+	if !found {
+		return
+	}
+	if _, err := c.append(diskFlagDelete, key, nil, 0); err != nil {
+		log.Println("disk cache remove:", err)
+		return
+	}
+	delete(c.index, key)
+	c.dead += old.size() + diskHeaderSize + int64(len(key))
+	c.watchers.notify(EventRemove, key, c.clock.Now())
+}
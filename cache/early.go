@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type EarlyConfig struct {
+	// Jitter spreads each Duration by up to ±Jitter of itself, so items
+	// created together do not all expire together. 0.1 means ±10%.
+	Jitter float64
+	// Beta scales XFetch probabilistic early recomputation. Values above 1
+	// favour earlier recomputation; zero disables it.
+	Beta  float64
+	Clock Clock
+}
+
+// Early avoids synchronized expiry stampedes. Durations are jittered on write,
+// and GetOrSet may recompute a value before it expires with a probability
+// that grows as expiry approaches and with how long create took last time
+// (XFetch, Vattani et al. 2015).
+func NewEarly(c Cache, cfg *EarlyConfig) *Early {
+	if cfg == nil {
+		cfg = &EarlyConfig{Jitter: 0.1, Beta: 1}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
+	}
+	return &Early{Cache: c, Config: cfg}
+}
+
+type Early struct {
+	Cache
+	Config *EarlyConfig
+}
+
+type earlyItem struct {
+	Value      json.RawMessage `json:"value"`
+	Delta      int64           `json:"delta"`
+	Expiration int64           `json:"expiration"`
+}
+
+func (c *Early) jitter(d time.Duration) time.Duration {
+	if d <= 0 || c.Config.Jitter <= 0 {
+		return d
+	}
+	d += time.Duration(float64(d) * c.Config.Jitter * (2*rand.Float64() - 1))
+	if d <= 0 {
+		d = 1
+	}
+	return d
+}
+
+func (c *Early) wrap(create func() (*Item, error)) func() (*Item, error) {
+	return func() (*Item, error) {
+		start := c.Config.Clock.Now()
+		item, err := create()
+		if err != nil {
+			return nil, err
+		}
+		body, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		now := c.Config.Clock.Now()
+		entry := earlyItem{Value: body, Delta: int64(now.Sub(start))}
+		d := c.jitter(item.Duration)
+		if d != 0 {
+			entry.Expiration = now.Add(d).UnixNano()
+		}
+		return &Item{Value: entry, Duration: d}, nil
+	}
+}
+
+func (c *Early) expired(entry earlyItem) bool {
+	if entry.Expiration == 0 || c.Config.Beta <= 0 {
+		return false
+	}
+	early := float64(entry.Delta) * c.Config.Beta * -math.Log(1-rand.Float64())
+	return float64(c.Config.Clock.Now().UnixNano())+early >= float64(entry.Expiration)
+}
+
+func (c *Early) Keys(prefix string) ([]string, error) {
+	return listKeys(c.Cache, prefix)
+}
+
+func (c *Early) Get(key string, result interface{}) error {
+	var entry earlyItem
+	if err := c.Cache.Get(key, &entry); err != nil {
+		return err
+	}
+	return json.Unmarshal(entry.Value, result)
+}
+
+func (c *Early) Set(key string, create func() (*Item, error)) error {
+	return c.Cache.Set(key, c.wrap(create))
+}
+
+func (c *Early) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	var entry earlyItem
+	if err := c.Cache.Get(key, &entry); err == nil {
+		// the current value is still valid, so a failed early recompute
+		// falls back to serving it
+		if c.expired(entry) && c.Cache.Set(key, c.wrap(create)) == nil {
+			c.Cache.Get(key, &entry)
+		}
+		return json.Unmarshal(entry.Value, result)
+	}
+	if err := c.Cache.GetOrSet(key, &entry, c.wrap(create)); err != nil {
+		return err
+	}
+	return json.Unmarshal(entry.Value, result)
+}
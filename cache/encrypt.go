@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrUnknownKey = errors.New("cache: unknown encryption key")
+	ErrCiphertext = errors.New("cache: malformed ciphertext")
+)
+
+// Cipher seals values with AES-GCM. Values are always sealed with the active
+// key; every key added to the ring stays usable for decryption, so keys can be
+// rotated without rewriting what is already stored.
+func NewCipher(id string, key []byte) (*Cipher, error) {
+	c := &Cipher{aeads: make(map[string]cipher.AEAD)}
+	if err := c.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type Cipher struct {
+	mu     sync.RWMutex
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+func (c *Cipher) AddKey(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("cache: invalid key id %q", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aeads[id] = aead
+	return nil
+}
+
+func (c *Cipher) Rotate(id string, key []byte) error {
+	if err := c.AddKey(id, key); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = id
+	return nil
+}
+
+func (c *Cipher) RemoveKey(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id == c.active {
+		return fmt.Errorf("cache: key %q is active", id)
+	}
+	delete(c.aeads, id)
+	return nil
+}
+
+func (c *Cipher) ActiveKey() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active
+}
+
+// Encrypt seals plain for the given cache key. The output is laid out as
+// len(id) | id | nonce | ciphertext, and the cache key is bound as additional
+// data so a sealed value cannot be replayed under another key.
+func (c *Cipher) Encrypt(key string, plain []byte) ([]byte, error) {
+	c.mu.RLock()
+	id, aead := c.active, c.aeads[c.active]
+	c.mu.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 1+len(id)+len(nonce)+len(plain)+aead.Overhead())
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, additionalData(id, key)), nil
+}
+
+func (c *Cipher) Decrypt(key string, sealed []byte) ([]byte, error) {
+	if len(sealed) < 1 || len(sealed) < 1+int(sealed[0]) {
+		return nil, ErrCiphertext
+	}
+	id := string(sealed[1 : 1+sealed[0]])
+	sealed = sealed[1+len(id):]
+
+	c.mu.RLock()
+	aead, found := c.aeads[id]
+	c.mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCiphertext
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData(id, key))
+}
+
+func additionalData(id, key string) []byte {
+	return []byte(id + "\x00" + key)
+}
+
+// Encrypted seals values before handing them to the wrapped cache, so File
+// snapshots, DB rows and Redis values only ever contain ciphertext.
+func NewEncrypted(c Cache, cipher *Cipher) *Encrypted {
+	return &Encrypted{Cache: c, cipher: cipher}
+}
+
+type Encrypted struct {
+	Cache
+	cipher *Cipher
+}
+
+func (c *Encrypted) seal(key string, create func() (*Item, error)) func() (*Item, error) {
+	return func() (*Item, error) {
+		item, err := create()
+		if err != nil {
+			return nil, err
+		}
+		body, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := c.cipher.Encrypt(key, body)
+		if err != nil {
+			return nil, err
+		}
+		return &Item{Value: sealed, Duration: item.Duration}, nil
+	}
+}
+
+func (c *Encrypted) open(key string, sealed []byte, result interface{}) error {
+	body, err := c.cipher.Decrypt(key, sealed)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, result)
+}
+
+func (c *Encrypted) Keys(prefix string) ([]string, error) {
+	return listKeys(c.Cache, prefix)
+}
+
+func (c *Encrypted) Get(key string, result interface{}) error {
+	var sealed []byte
+	if err := c.Cache.Get(key, &sealed); err != nil {
+		return err
+	}
+	return c.open(key, sealed, result)
+}
+
+func (c *Encrypted) Set(key string, create func() (*Item, error)) error {
+	return c.Cache.Set(key, c.seal(key, create))
+}
+
+func (c *Encrypted) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	var sealed []byte
+	if err := c.Cache.GetOrSet(key, &sealed, c.seal(key, create)); err != nil {
+		return err
+	}
+	return c.open(key, sealed, result)
+}
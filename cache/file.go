@@ -10,11 +10,12 @@ import (
 	"time"
 )
 
-func NewFile(fp string) *File {
+func NewFile(fp string, opts ...Option) *File {
+	o := newOptions(opts)
 	c := &File{
-		Memory:     NewMemory(),
+		Memory:     NewMemory(opts...),
 		fp:         fp,
-		saveTicker: time.NewTicker(time.Second * 30),
+		saveTicker: o.clock.NewTicker(time.Second * 30),
 		saveStop:   make(chan bool),
 	}
 	dir := path.Dir(c.fp)
@@ -31,14 +32,14 @@ func NewFile(fp string) *File {
 type File struct {
 	*Memory
 	fp         string
-	saveTicker *time.Ticker
+	saveTicker Ticker
 	saveStop   chan bool
 }
 
 func (c *File) saveLoop() {
 	for {
 		select {
-		case <-c.saveTicker.C:
+		case <-c.saveTicker.C():
 			if err := c.save(); err != nil {
 				log.Println("file cache save:", err)
 			}
@@ -95,7 +96,7 @@ func (c *File) gobsave(w io.Writer) (err error) {
 }
 
 func (c *File) gobload(r io.Reader) error {
-	now := time.Now().UnixNano()
+	now := c.clock.Now().UnixNano()
 	dec := gob.NewDecoder(r)
 	storage := map[string]memoryItem{}
 	if err := dec.Decode(&storage); err != nil {
@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// GormSkip set on a session bypasses the cache for that query:
+//
+//	db.Set(cache.GormSkip, true).First(&user, id)
+const GormSkip = "gotools:cache:skip"
+
+// GormPlugin caches single-row lookups by primary key, such as
+// db.First(&user, 10) or db.Take(&User{ID: 10}), and drops the cached row
+// when the model is created, updated or deleted through gorm. Updates and
+// deletes that can't be traced back to primary keys clear every cached row
+// of the table, provided the cache implements KeyLister.
+//
+// Rows are dropped once gorm's own transaction has committed, so a
+// concurrent read can't cache the old row in between. Writes inside an
+// explicit db.Transaction block are dropped before that transaction commits
+// and have this race; remove their keys again after the commit if it
+// matters.
+//
+// Rows are stored as JSON, so models must round-trip through encoding/json.
+func NewGormPlugin(c Cache, d time.Duration) *GormPlugin {
+	return &GormPlugin{Cache: c, Duration: d, Prefix: "gorm:"}
+}
+
+type GormPlugin struct {
+	Cache    Cache
+	Duration time.Duration
+	Prefix   string
+}
+
+func (p *GormPlugin) Name() string {
+	return "gotools:cache"
+}
+
+func (p *GormPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Replace("gorm:query", p.query); err != nil {
+		return err
+	}
+	if err := db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("gotools:cache_invalidate", p.invalidate); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("gotools:cache_invalidate", p.invalidate); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("gotools:cache_invalidate", p.invalidate)
+}
+
+func (p *GormPlugin) key(table string, value interface{}) string {
+	return fmt.Sprintf("%s%s:%v", p.Prefix, table, value)
+}
+
+// asColumn accepts both forms gorm uses for columns in conditions: updates
+// and deletes by model name them with a plain string.
+func asColumn(c interface{}) (clause.Column, bool) {
+	switch c := c.(type) {
+	case clause.Column:
+		return c, true
+	case string:
+		return clause.Column{Name: c}, true
+	}
+	return clause.Column{}, false
+}
+
+func primaryValues(stmt *gorm.Statement, exprs []clause.Expression) ([]interface{}, bool) {
+	field := stmt.Schema.PrioritizedPrimaryField
+	var values []interface{}
+	for _, expr := range exprs {
+		var (
+			column clause.Column
+			vals   []interface{}
+		)
+		var ok bool
+		switch e := expr.(type) {
+		case clause.Eq:
+			column, ok = asColumn(e.Column)
+			vals = []interface{}{e.Value}
+		case clause.IN:
+			column, ok = asColumn(e.Column)
+			vals = e.Values
+		}
+		if !ok {
+			return nil, false
+		}
+		if column.Name != clause.PrimaryKey && column.Name != field.DBName && column.Name != field.Name {
+			return nil, false
+		}
+		values = append(values, vals...)
+	}
+	return values, len(values) > 0
+}
+
+// lookupKey returns the cache key of a query that fetches exactly one row by
+// its primary key and nothing else.
+func (p *GormPlugin) lookupKey(db *gorm.DB) (string, bool) {
+	stmt := db.Statement
+	if skip, ok := db.Get(GormSkip); ok && skip == true {
+		return "", false
+	}
+	if stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil || len(stmt.Schema.PrimaryFields) != 1 {
+		return "", false
+	}
+	if stmt.SQL.Len() > 0 || stmt.Unscoped || stmt.Distinct || len(stmt.Selects) > 0 || len(stmt.Omits) > 0 ||
+		len(stmt.Joins) > 0 || len(stmt.Preloads) > 0 {
+		return "", false
+	}
+	if stmt.ReflectValue.Kind() != reflect.Struct || stmt.ReflectValue.Type() != stmt.Schema.ModelType {
+		return "", false
+	}
+	for name, c := range stmt.Clauses {
+		switch name {
+		case "WHERE", "ORDER BY":
+		case "LIMIT":
+			// an offset skips the one row the key could match
+			if limit, ok := c.Expression.(clause.Limit); !ok || limit.Offset != 0 {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+	}
+
+	var values []interface{}
+	dest, isZero := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.ReflectValue)
+	if where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where); ok {
+		// gorm adds the primary key of the destination as another condition
+		if !isZero {
+			return "", false
+		}
+		var found bool
+		if values, found = primaryValues(stmt, where.Exprs); !found {
+			return "", false
+		}
+	} else if !isZero {
+		values = []interface{}{dest}
+	}
+	if len(values) != 1 {
+		return "", false
+	}
+	return p.key(stmt.Table, values[0]), true
+}
+
+func (p *GormPlugin) query(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	key, ok := p.lookupKey(db)
+	if !ok {
+		callbacks.Query(db)
+		return
+	}
+	if err := p.Cache.Get(key, db.Statement.Dest); err == nil {
+		db.RowsAffected = 1
+		return
+	}
+	callbacks.Query(db)
+	if db.Error != nil || db.RowsAffected != 1 {
+		return
+	}
+	row := db.Statement.ReflectValue.Interface()
+	if err := p.Cache.Set(key, func() (*Item, error) {
+		return &Item{Value: row, Duration: p.Duration}, nil
+	}); err != nil {
+		log.Println("gorm cache set:", key, err)
+	}
+}
+
+func (p *GormPlugin) invalidate(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return
+	}
+	field := stmt.Schema.PrioritizedPrimaryField
+	var values []interface{}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Struct:
+		if v, isZero := field.ValueOf(stmt.ReflectValue); !isZero {
+			values = append(values, v)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			if v, isZero := field.ValueOf(reflect.Indirect(stmt.ReflectValue.Index(i))); !isZero {
+				values = append(values, v)
+			}
+		}
+	}
+
+	if where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where); ok {
+		pks, found := primaryValues(stmt, where.Exprs)
+		if !found {
+			p.invalidateTable(stmt.Schema, stmt.Table)
+			return
+		}
+		values = append(values, pks...)
+	} else if len(values) == 0 && stmt.SQL.Len() > 0 {
+		// no WHERE clause and no primary keys: an unrestricted update or
+		// delete that may have touched every row
+		p.invalidateTable(stmt.Schema, stmt.Table)
+		return
+	}
+	for _, v := range values {
+		p.Cache.Remove(p.key(stmt.Table, v))
+	}
+}
+
+func (p *GormPlugin) invalidateTable(s *schema.Schema, table string) {
+	keys, err := listKeys(p.Cache, p.key(table, ""))
+	if err != nil {
+		log.Println("gorm cache invalidate:", s.Name, err)
+		return
+	}
+	for _, k := range keys {
+		p.Cache.Remove(k)
+	}
+}
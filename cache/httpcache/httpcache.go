@@ -0,0 +1,345 @@
+// Package httpcache caches net/http responses in a cache.Cache.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xxiss/gotools/cache"
+)
+
+type KeyFunc func(r *http.Request) string
+
+type Route struct {
+	// Prefix selects the requests this route applies to by URL path.
+	Prefix   string
+	Key      KeyFunc
+	Duration time.Duration
+	// Disable turns caching off for the route.
+	Disable bool
+}
+
+type Config struct {
+	Cache cache.Cache
+	// Duration is used when the response sets no max-age.
+	Duration time.Duration
+	// MaxBody is the largest response body that is cached.
+	MaxBody int
+	Key     KeyFunc
+	Routes  []Route
+}
+
+func DefaultKey(r *http.Request) string {
+	return r.Method + " " + r.Host + r.URL.RequestURI()
+}
+
+func New(cfg *Config) *Middleware {
+	if cfg.Duration == 0 {
+		cfg.Duration = time.Minute
+	}
+	if cfg.MaxBody == 0 {
+		cfg.MaxBody = 1 << 20
+	}
+	if cfg.Key == nil {
+		cfg.Key = DefaultKey
+	}
+	return &Middleware{Config: cfg, calls: make(map[string]*call)}
+}
+
+type Middleware struct {
+	*Config
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg   sync.WaitGroup
+	resp *response
+}
+
+// response is what gets stored. Vary holds the request headers the response
+// varies on; it is stored on its own under the base key so later requests
+// know which headers to fold into the variant key.
+type response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Vary   []string    `json:"vary,omitempty"`
+}
+
+func (m *Middleware) route(r *http.Request) Route {
+	best := Route{Key: m.Key, Duration: m.Duration}
+	match := -1
+	for _, rt := range m.Routes {
+		if strings.HasPrefix(r.URL.Path, rt.Prefix) && len(rt.Prefix) > match {
+			best, match = rt, len(rt.Prefix)
+			if best.Key == nil {
+				best.Key = m.Key
+			}
+			if best.Duration == 0 {
+				best.Duration = m.Duration
+			}
+		}
+	}
+	return best
+}
+
+func variantKey(base string, vary []string, r *http.Request) string {
+	if len(vary) == 0 {
+		return base
+	}
+	h := sha1.New()
+	for _, name := range vary {
+		h.Write([]byte(name + ":" + strings.Join(r.Header.Values(name), ",") + "\n"))
+	}
+	return base + "#" + hex.EncodeToString(h.Sum(nil))
+}
+
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value := part, ""
+			if i := strings.IndexByte(part, '='); i >= 0 {
+				name, value = part[:i], strings.Trim(part[i+1:], `"`)
+			}
+			directives[strings.ToLower(name)] = value
+		}
+	}
+	return directives
+}
+
+func parseVary(h http.Header) ([]string, bool) {
+	var vary []string
+	for _, line := range h.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+	return vary, true
+}
+
+// ttl reports how long a response may be stored, or zero if it must not be.
+func ttl(resp *response, fallback time.Duration) time.Duration {
+	if resp.Status != http.StatusOK && resp.Status != http.StatusNotFound && resp.Status != http.StatusMovedPermanently {
+		return 0
+	}
+	if resp.Header.Get("Set-Cookie") != "" {
+		return 0
+	}
+	cc := cacheControl(resp.Header)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, found := cc[d]; found {
+			return 0
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, found := cc[d]; found {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0
+			}
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return fallback
+}
+
+func cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	cc := cacheControl(r.Header)
+	_, noStore := cc["no-store"]
+	return !noStore
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := m.route(r)
+		if rt.Disable || !cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		base := rt.Key(r)
+		_, noCache := cacheControl(r.Header)["no-cache"]
+
+		var resp response
+		if !noCache && m.lookup(base, r, &resp) {
+			w.Header().Set("X-Cache", "HIT")
+			m.write(w, r, &resp)
+			return
+		}
+
+		// concurrent misses for the same key wait for the first request
+		// instead of all reaching the handler
+		m.mu.Lock()
+		if c, found := m.calls[base]; found {
+			m.mu.Unlock()
+			c.wg.Wait()
+			if c.resp != nil {
+				resp = *c.resp
+			}
+			if c.resp != nil || m.lookup(base, r, &resp) {
+				w.Header().Set("X-Cache", "HIT")
+				m.write(w, r, &resp)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		c := new(call)
+		c.wg.Add(1)
+		m.calls[base] = c
+		m.mu.Unlock()
+
+		rec := &recorder{ResponseWriter: w, header: make(http.Header), status: http.StatusOK, max: m.MaxBody}
+		func() {
+			defer func() {
+				m.mu.Lock()
+				delete(m.calls, base)
+				m.mu.Unlock()
+				c.wg.Done()
+			}()
+			next.ServeHTTP(rec, r)
+			if !rec.wroteHeader {
+				rec.WriteHeader(http.StatusOK)
+			}
+			if rec.overflow {
+				return
+			}
+			resp := &response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+			vary, ok := parseVary(resp.Header)
+			d := ttl(resp, rt.Duration)
+			if !ok || d == 0 {
+				return
+			}
+			resp.Vary = vary
+			if err := m.store(base, r, resp, d); err == nil && len(vary) == 0 {
+				c.resp = resp
+			}
+		}()
+	})
+}
+
+func (m *Middleware) lookup(base string, r *http.Request, resp *response) bool {
+	var head response
+	if err := m.Cache.Get(base, &head); err != nil {
+		return false
+	}
+	if len(head.Vary) == 0 {
+		*resp = head
+		return true
+	}
+	return m.Cache.Get(variantKey(base, head.Vary, r), resp) == nil
+}
+
+func (m *Middleware) store(base string, r *http.Request, resp *response, d time.Duration) error {
+	if len(resp.Vary) > 0 {
+		marker := &response{Vary: resp.Vary}
+		if err := m.Cache.Set(base, func() (*cache.Item, error) {
+			return &cache.Item{Value: marker, Duration: d}, nil
+		}); err != nil {
+			return err
+		}
+	}
+	return m.Cache.Set(variantKey(base, resp.Vary, r), func() (*cache.Item, error) {
+		return &cache.Item{Value: resp, Duration: d}, nil
+	})
+}
+
+func (m *Middleware) write(w http.ResponseWriter, r *http.Request, resp *response) {
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && resp.Status == http.StatusOK && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(resp.Status)
+	if r.Method != http.MethodHead {
+		w.Write(resp.Body)
+	}
+}
+
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	weak := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == weak {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder passes the response through to the client while keeping a copy
+// of it, up to max body bytes.
+type recorder struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	max         int
+	overflow    bool
+	wroteHeader bool
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.status = status
+	for k, v := range r.header {
+		r.ResponseWriter.Header()[k] = v
+	}
+	r.ResponseWriter.Header().Set("X-Cache", "MISS")
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.overflow {
+		if r.body.Len()+len(b) > r.max {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Loader interface {
+	Load(key string) (*Item, error)
+}
+
+type LoaderFunc func(key string) (*Item, error)
+
+func (f LoaderFunc) Load(key string) (*Item, error) {
+	return f(key)
+}
+
+type LoadingConfig struct {
+	// RefreshAhead reloads a hot key once less than this much of its TTL is
+	// left. Zero disables refresh-ahead.
+	RefreshAhead time.Duration
+	// HotWindow is how recently a key must have been read to be refreshed.
+	HotWindow time.Duration
+	// Interval is how often keys are scanned for refresh.
+	Interval time.Duration
+	// Workers bounds the number of concurrent refreshes.
+	Workers int
+	// OnRefreshError is called when a background refresh fails.
+	OnRefreshError func(key string, err error)
+	Clock          Clock
+}
+
+// Loading is a read-through view of a cache: Get loads a missing key with
+// the Loader registered for the longest matching key prefix, and keys that
+// are read often are reloaded shortly before they expire.
+func NewLoading(c Cache, cfg *LoadingConfig) *Loading {
+	if cfg == nil {
+		cfg = &LoadingConfig{}
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.HotWindow == 0 {
+		cfg.HotWindow = time.Minute
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
+	}
+	l := &Loading{
+		Cache:         c,
+		Config:        cfg,
+		loaders:       make(map[string]Loader),
+		entries:       make(map[string]*loadingEntry),
+		refreshTicker: cfg.Clock.NewTicker(cfg.Interval),
+		refreshStop:   make(chan bool),
+		queue:         make(chan string, cfg.Workers),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		go l.refreshWorker()
+	}
+	go l.refreshLoop()
+	return l
+}
+
+type loadingEntry struct {
+	Expiration int64
+	Access     int64
+	Refreshing bool
+}
+
+type Loading struct {
+	Cache
+	Config *LoadingConfig
+
+	mu            sync.RWMutex
+	loaders       map[string]Loader
+	entries       map[string]*loadingEntry
+	refreshTicker Ticker
+	refreshStop   chan bool
+	queue         chan string
+}
+
+func (c *Loading) Register(prefix string, l Loader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaders[prefix] = l
+}
+
+func (c *Loading) loader(key string) Loader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var (
+		match  string
+		loader Loader
+	)
+	for prefix, l := range c.loaders {
+		if strings.HasPrefix(key, prefix) && (loader == nil || len(prefix) > len(match)) {
+			match, loader = prefix, l
+		}
+	}
+	return loader
+}
+
+func (c *Loading) touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, found := c.entries[key]; found {
+		entry.Access = c.Config.Clock.Now().UnixNano()
+	}
+}
+
+func (c *Loading) load(key string, l Loader) func() (*Item, error) {
+	return func() (*Item, error) {
+		item, err := l.Load(key)
+		if err != nil {
+			return nil, err
+		}
+		now := c.Config.Clock.Now()
+		entry := &loadingEntry{Access: now.UnixNano()}
+		if item.Duration != 0 {
+			entry.Expiration = now.Add(item.Duration).UnixNano()
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if old, found := c.entries[key]; found {
+			entry.Access = old.Access
+		}
+		c.entries[key] = entry
+		return item, nil
+	}
+}
+
+func (c *Loading) refreshLoop() {
+	for {
+		select {
+		case <-c.refreshTicker.C():
+			c.scheduleRefresh()
+		case <-c.refreshStop:
+			c.refreshTicker.Stop()
+			close(c.queue)
+			return
+		}
+	}
+}
+
+func (c *Loading) scheduleRefresh() {
+	if c.Config.RefreshAhead <= 0 {
+		return
+	}
+	now := c.Config.Clock.Now().UnixNano()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		hot := now-entry.Access <= int64(c.Config.HotWindow)
+		if entry.Expiration == 0 || (!hot && now > entry.Expiration) {
+			delete(c.entries, key)
+			continue
+		}
+		if !hot || entry.Refreshing || entry.Expiration-now > int64(c.Config.RefreshAhead) {
+			continue
+		}
+		select {
+		case c.queue <- key:
+			entry.Refreshing = true
+		default:
+			return
+		}
+	}
+}
+
+func (c *Loading) refreshWorker() {
+	for key := range c.queue {
+		err := ErrNotFound
+		if l := c.loader(key); l != nil {
+			err = c.Cache.Set(key, c.load(key, l))
+		}
+		if err != nil {
+			c.mu.Lock()
+			if entry, found := c.entries[key]; found {
+				entry.Refreshing = false
+			}
+			c.mu.Unlock()
+
+			if c.Config.OnRefreshError != nil {
+				c.Config.OnRefreshError(key, err)
+			} else {
+				log.Println("cache refresh:", key, err)
+			}
+		}
+	}
+}
+
+func (c *Loading) StopRefresh() {
+	c.refreshStop <- true
+}
+
+func (c *Loading) Keys(prefix string) ([]string, error) {
+	return listKeys(c.Cache, prefix)
+}
+
+func (c *Loading) Get(key string, result interface{}) error {
+	l := c.loader(key)
+	if l == nil {
+		return c.Cache.Get(key, result)
+	}
+	return c.GetOrSet(key, result, c.load(key, l))
+}
+
+func (c *Loading) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	if err := c.Cache.GetOrSet(key, result, create); err != nil {
+		return err
+	}
+	c.touch(key)
+	return nil
+}
+
+func (c *Loading) Remove(key string) {
+	c.Cache.Remove(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
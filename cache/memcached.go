@@ -0,0 +1,408 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMemcachedMiss     = errors.New("memcached: cache miss")
+	ErrMemcachedNotStore = errors.New("memcached: item not stored")
+	ErrMemcachedCAS      = errors.New("memcached: compare-and-swap conflict")
+)
+
+const memcachedMaxRelativeExpiration = 60 * 60 * 24 * 30
+
+type Memcached struct {
+	Addr    string
+	Timeout time.Duration
+	MaxIdle int
+
+	mu     sync.RWMutex
+	mus    map[string]*sync.RWMutex
+	connMu sync.Mutex
+	idle   []*memcachedConn
+}
+
+type memcachedConn struct {
+	net.Conn
+	rw *bufio.ReadWriter
+}
+
+type memcachedItem struct {
+	Key   string
+	Value []byte
+	CAS   uint64
+}
+
+func NewMemcached(host string, port int) (*Memcached, error) {
+	c := &Memcached{
+		Addr:    fmt.Sprintf("%s:%d", host, port),
+		Timeout: time.Second * 3,
+		MaxIdle: 8,
+		mus:     make(map[string]*sync.RWMutex),
+	}
+	if _, err := c.Version(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Memcached) gcRWMutex(key string) *sync.RWMutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mus[key] == nil {
+		c.mus[key] = &sync.RWMutex{}
+	}
+	return c.mus[key]
+}
+
+func (c *Memcached) conn() (*memcachedConn, error) {
+	c.connMu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.connMu.Unlock()
+		return cn, nil
+	}
+	c.connMu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &memcachedConn{
+		Conn: nc,
+		rw:   bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+	}, nil
+}
+
+func (c *Memcached) release(cn *memcachedConn, err error) {
+	// protocol level errors leave the connection usable, anything else may
+	// have left unread bytes on the wire
+	if err != nil && err != ErrMemcachedMiss && err != ErrMemcachedNotStore && err != ErrMemcachedCAS {
+		cn.Close()
+		return
+	}
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if len(c.idle) >= c.MaxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func (c *Memcached) do(fn func(cn *memcachedConn) error) error {
+	cn, err := c.conn()
+	if err != nil {
+		return err
+	}
+	if c.Timeout > 0 {
+		cn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	err = fn(cn)
+	c.release(cn, err)
+	return err
+}
+
+func (c *Memcached) Close() error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func checkMemcachedKey(key string) error {
+	if len(key) == 0 || len(key) > 250 {
+		return fmt.Errorf("memcached: invalid key length %d", len(key))
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("memcached: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+func memcachedExpiration(d time.Duration) int64 {
+	switch {
+	case d < 0:
+		// a negative expiration makes the item expire immediately
+		return -1
+	case d == 0:
+		return 0
+	}
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs > memcachedMaxRelativeExpiration {
+		return time.Now().Unix() + secs
+	}
+	return secs
+}
+
+func readMemcachedLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch {
+	case line == "ERROR":
+		return "", errors.New("memcached: unknown command")
+	case strings.HasPrefix(line, "CLIENT_ERROR "), strings.HasPrefix(line, "SERVER_ERROR "):
+		return "", errors.New("memcached: " + strings.ToLower(line))
+	}
+	return line, nil
+}
+
+func (c *Memcached) Version() (string, error) {
+	var version string
+	err := c.do(func(cn *memcachedConn) error {
+		if _, err := cn.rw.WriteString("version\r\n"); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(cn.rw.Reader)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "VERSION ") {
+			return fmt.Errorf("memcached: unexpected response %q", line)
+		}
+		version = strings.TrimPrefix(line, "VERSION ")
+		return nil
+	})
+	return version, err
+}
+
+func (c *Memcached) gets(keys []string) (map[string]*memcachedItem, error) {
+	for _, key := range keys {
+		if err := checkMemcachedKey(key); err != nil {
+			return nil, err
+		}
+	}
+	items := make(map[string]*memcachedItem, len(keys))
+	err := c.do(func(cn *memcachedConn) error {
+		if _, err := fmt.Fprintf(cn.rw, "gets %s\r\n", strings.Join(keys, " ")); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := readMemcachedLine(cn.rw.Reader)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			// VALUE <key> <flags> <bytes> <cas unique>
+			fields := strings.Fields(line)
+			if len(fields) != 5 || fields[0] != "VALUE" {
+				return fmt.Errorf("memcached: unexpected response %q", line)
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return err
+			}
+			cas, err := strconv.ParseUint(fields[4], 10, 64)
+			if err != nil {
+				return err
+			}
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(cn.rw, value); err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(value, []byte("\r\n")) {
+				return fmt.Errorf("memcached: corrupt value for %s", fields[1])
+			}
+			items[fields[1]] = &memcachedItem{Key: fields[1], Value: value[:size], CAS: cas}
+		}
+	})
+	return items, err
+}
+
+func (c *Memcached) store(verb string, item *memcachedItem, d time.Duration) error {
+	if err := checkMemcachedKey(item.Key); err != nil {
+		return err
+	}
+	return c.do(func(cn *memcachedConn) error {
+		var err error
+		if verb == "cas" {
+			_, err = fmt.Fprintf(cn.rw, "cas %s 0 %d %d %d\r\n", item.Key, memcachedExpiration(d), len(item.Value), item.CAS)
+		} else {
+			_, err = fmt.Fprintf(cn.rw, "%s %s 0 %d %d\r\n", verb, item.Key, memcachedExpiration(d), len(item.Value))
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := cn.rw.Write(item.Value); err != nil {
+			return err
+		}
+		if _, err := cn.rw.WriteString("\r\n"); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(cn.rw.Reader)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "STORED":
+			return nil
+		case "NOT_STORED":
+			return ErrMemcachedNotStore
+		case "EXISTS":
+			return ErrMemcachedCAS
+		case "NOT_FOUND":
+			return ErrMemcachedMiss
+		}
+		return fmt.Errorf("memcached: unexpected response %q", line)
+	})
+}
+
+func (c *Memcached) delete(key string) error {
+	if err := checkMemcachedKey(key); err != nil {
+		return err
+	}
+	return c.do(func(cn *memcachedConn) error {
+		if _, err := fmt.Fprintf(cn.rw, "delete %s\r\n", key); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(cn.rw.Reader)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "DELETED":
+			return nil
+		case "NOT_FOUND":
+			return ErrMemcachedMiss
+		}
+		return fmt.Errorf("memcached: unexpected response %q", line)
+	})
+}
+
+// LockRun takes the lock with add, which only succeeds for a missing key, and
+// releases it with cas so a lock that expired and was taken over by someone
+// else is never deleted.
+func (c *Memcached) LockRun(key string, d time.Duration, fn func() error) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	lock := &memcachedItem{Key: key, Value: []byte(hex.EncodeToString(token))}
+	if err := c.store("add", lock, d); err != nil {
+		if err == ErrMemcachedNotStore {
+			return fmt.Errorf("the system is busy. please try again later. key:%s", key)
+		}
+		return err
+	}
+	defer func() {
+		items, err := c.gets([]string{key})
+		if err != nil {
+			return
+		}
+		if held, found := items[key]; found && bytes.Equal(held.Value, lock.Value) {
+			held.Value = nil
+			c.store("cas", held, -time.Second)
+		}
+	}()
+	return fn()
+}
+
+func (c *Memcached) Get(key string, result interface{}) error {
+	items, err := c.gets([]string{key})
+	if err != nil {
+		return err
+	}
+	item, found := items[key]
+	if !found {
+		return ErrMemcachedMiss
+	}
+	return json.Unmarshal(item.Value, result)
+}
+
+// GetMulti fetches several keys in one round trip. Missing keys are left out
+// of the returned map.
+func (c *Memcached) GetMulti(keys []string) (map[string]json.RawMessage, error) {
+	items, err := c.gets(keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage, len(items))
+	for k, item := range items {
+		values[k] = item.Value
+	}
+	return values, nil
+}
+
+func (c *Memcached) Set(key string, create func() (*Item, error)) error {
+	mu := c.gcRWMutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	item, err := create()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(item.Value)
+	if err != nil {
+		return err
+	}
+	return c.store("set", &memcachedItem{Key: key, Value: body}, item.Duration)
+}
+
+func (c *Memcached) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	mu := c.gcRWMutex(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	items, err := c.gets([]string{key})
+	if err != nil {
+		return err
+	}
+	if entry, found := items[key]; found {
+		return json.Unmarshal(entry.Value, result)
+	}
+
+	item, err := create()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(item.Value)
+	if err != nil {
+		return err
+	}
+	if err := c.store("set", &memcachedItem{Key: key, Value: body}, item.Duration); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+func (c *Memcached) Remove(key string) {
+	c.delete(key)
+}
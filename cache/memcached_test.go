@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memcachedStub speaks the subset of the memcached text protocol Memcached
+// uses. Items never expire, except that a negative exptime drops them at
+// once, as memcached does.
+type memcachedStub struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	items map[string]memcachedStubItem
+	cas   uint64
+}
+
+type memcachedStubItem struct {
+	value []byte
+	cas   uint64
+}
+
+func newMemcachedStub(t *testing.T) *memcachedStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memcachedStub{ln: ln, items: make(map[string]memcachedStubItem)}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	return s
+}
+
+func (s *memcachedStub) client(t *testing.T) *Memcached {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	c, err := NewMemcached(addr.IP.String(), addr.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+func (s *memcachedStub) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(nc)
+	}
+}
+
+func (s *memcachedStub) handle(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var reply string
+		switch fields[0] {
+		case "version":
+			reply = "VERSION stub\r\n"
+		case "gets":
+			reply = s.gets(fields[1:])
+		case "set", "add", "cas":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			reply = s.store(fields, data[:size])
+		case "delete":
+			reply = s.delete(fields[1])
+		default:
+			reply = "ERROR\r\n"
+		}
+		if _, err := io.WriteString(nc, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *memcachedStub) gets(keys []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, key := range keys {
+		if item, found := s.items[key]; found {
+			fmt.Fprintf(&b, "VALUE %s 0 %d %d\r\n%s\r\n", key, len(item.value), item.cas, item.value)
+		}
+	}
+	b.WriteString("END\r\n")
+	return b.String()
+}
+
+// store handles "<verb> <key> <flags> <exptime> <bytes> [<cas unique>]".
+func (s *memcachedStub) store(fields []string, value []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fields[1]
+	held, found := s.items[key]
+	switch fields[0] {
+	case "add":
+		if found {
+			return "NOT_STORED\r\n"
+		}
+	case "cas":
+		if !found {
+			return "NOT_FOUND\r\n"
+		}
+		if cas, _ := strconv.ParseUint(fields[5], 10, 64); cas != held.cas {
+			return "EXISTS\r\n"
+		}
+	}
+	if exptime, _ := strconv.Atoi(fields[3]); exptime < 0 {
+		delete(s.items, key)
+		return "STORED\r\n"
+	}
+	s.cas++
+	s.items[key] = memcachedStubItem{value: append([]byte(nil), value...), cas: s.cas}
+	return "STORED\r\n"
+}
+
+func (s *memcachedStub) delete(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.items[key]; !found {
+		return "NOT_FOUND\r\n"
+	}
+	delete(s.items, key)
+	return "DELETED\r\n"
+}
+
+func (s *memcachedStub) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, found := s.items[key]
+	return found
+}
+
+func TestMemcachedSetGetRemove(t *testing.T) {
+	c := newMemcachedStub(t).client(t)
+
+	err := c.Set("a", func() (*Item, error) {
+		return &Item{Value: map[string]int{"n": 1}, Duration: time.Minute}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	if err := c.Get("a", &got); err != nil || got["n"] != 1 {
+		t.Fatalf("Get = %v, %v, want n=1", got, err)
+	}
+
+	c.Remove("a")
+	if err := c.Get("a", &got); err != ErrMemcachedMiss {
+		t.Fatalf("Get after Remove = %v, want %v", err, ErrMemcachedMiss)
+	}
+}
+
+func TestMemcachedGetMulti(t *testing.T) {
+	c := newMemcachedStub(t).client(t)
+	for _, key := range []string{"a", "b"} {
+		key := key
+		if err := c.Set(key, func() (*Item, error) {
+			return &Item{Value: key}, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values, err := c.GetMulti([]string{"a", "b", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || string(values["a"]) != `"a"` || string(values["b"]) != `"b"` {
+		t.Fatalf("GetMulti = %q, want a and b only", values)
+	}
+}
+
+func TestMemcachedGetOrSet(t *testing.T) {
+	c := newMemcachedStub(t).client(t)
+	calls := 0
+	create := func() (*Item, error) {
+		calls++
+		return &Item{Value: 42}, nil
+	}
+	for i := 0; i < 2; i++ {
+		var got int
+		if err := c.GetOrSet("n", &got, create); err != nil || got != 42 {
+			t.Fatalf("GetOrSet = %d, %v, want 42", got, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("create called %d times, want 1", calls)
+	}
+}
+
+func TestMemcachedLockRun(t *testing.T) {
+	s := newMemcachedStub(t)
+	c := s.client(t)
+
+	err := c.LockRun("lock", time.Minute, func() error {
+		if !s.has("lock") {
+			t.Error("lock not held while running")
+		}
+		if err := c.LockRun("lock", time.Minute, func() error { return nil }); err == nil {
+			t.Error("second LockRun took a held lock")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.has("lock") {
+		t.Fatal("lock not released")
+	}
+
+	// a lock that expired and was taken over must survive the release
+	err = c.LockRun("lock", time.Minute, func() error {
+		return c.store("set", &memcachedItem{Key: "lock", Value: []byte("other")}, time.Minute)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.has("lock") {
+		t.Fatal("release removed a lock held by someone else")
+	}
+}
+
+func TestMemcachedLockRunNetworkError(t *testing.T) {
+	s := newMemcachedStub(t)
+	c := s.client(t)
+	c.Close()
+	s.ln.Close()
+
+	err := c.LockRun("lock", time.Minute, func() error { return nil })
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		t.Fatalf("LockRun = %v, want the network error", err)
+	}
+}
@@ -3,16 +3,19 @@ package cache
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
-func NewMemory() *Memory {
+func NewMemory(opts ...Option) *Memory {
+	o := newOptions(opts)
 	c := &Memory{
+		clock:    o.clock,
 		Storage:  make(map[string]memoryItem),
 		nx:       make(map[string]int64),
 		mus:      make(map[string]*sync.RWMutex),
-		gcTicker: time.NewTicker(time.Minute * 10),
+		gcTicker: o.clock.NewTicker(time.Minute * 10),
 		gcStop:   make(chan bool),
 	}
 	go c.gcLoop()
@@ -37,14 +40,16 @@ type Memory struct {
 	mu       sync.RWMutex
 	mus      map[string]*sync.RWMutex
 	nx       map[string]int64
-	gcTicker *time.Ticker
+	clock    Clock
+	watchers watchers
+	gcTicker Ticker
 	gcStop   chan bool
 }
 
 func (c *Memory) gcLoop() {
 	for {
 		select {
-		case <-c.gcTicker.C:
+		case <-c.gcTicker.C():
 			c.ClearExpired()
 		case <-c.gcStop:
 			c.gcTicker.Stop()
@@ -71,24 +76,61 @@ func (c *Memory) StopGC() {
 }
 
 func (c *Memory) ClearExpired() {
-	now := time.Now().UnixNano()
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.Storage {
+		if v.Expired(now.UnixNano()) {
+			delete(c.Storage, k)
+			c.watchers.notify(EventExpire, k, now)
+		}
+	}
+}
+
+// expire drops key if it is still expired when found so by a read, so
+// watchers hear of the expiry without waiting for the next ClearExpired.
+func (c *Memory) expire(key string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, found := c.Storage[key]; found && entry.Expired(now.UnixNano()) {
+		delete(c.Storage, key)
+		c.watchers.notify(EventExpire, key, now)
+	}
+}
+
+func (c *Memory) Watch(pattern string) (<-chan Event, func()) {
+	return c.watchers.add(pattern)
+}
+
+func (c *Memory) Keys(prefix string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.clock.Now().UnixNano()
+	keys := make([]string, 0)
 	for k, v := range c.Storage {
-		if v.Expired(now) {
-			c.Remove(k)
+		if strings.HasPrefix(k, prefix) && !v.Expired(now) {
+			keys = append(keys, k)
 		}
 	}
+	return keys, nil
 }
 
 func (c *Memory) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	now := c.clock.Now()
+	for k := range c.Storage {
+		c.watchers.notify(EventRemove, k, now)
+	}
 	c.Storage = make(map[string]memoryItem)
 }
 
 func (c *Memory) LockRun(key string, d time.Duration, fn func() error) error {
 	c.mu.Lock()
-	now := time.Now().UnixNano()
+	now := c.clock.Now().UnixNano()
 	if c.nx[key] != 0 && c.nx[key]+int64(d) > now {
 		c.mu.Unlock()
 		return fmt.Errorf("the system is busy. please try again later. key:%s", key)
@@ -105,10 +147,14 @@ func (c *Memory) LockRun(key string, d time.Duration, fn func() error) error {
 
 func (c *Memory) Get(key string, result interface{}) error {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-
 	entry, found := c.Storage[key]
-	if !found || entry.Expired(time.Now().UnixNano()) {
+	c.mu.RUnlock()
+
+	if now := c.clock.Now(); found && entry.Expired(now.UnixNano()) {
+		c.expire(key, now)
+		found = false
+	}
+	if !found {
 		return fmt.Errorf("%s doesn't exist", key)
 	}
 	return json.Unmarshal(entry.Body, &result)
@@ -129,12 +175,13 @@ func (c *Memory) Set(key string, create func() (*Item, error)) error {
 	}
 	mem := memoryItem{Body: body}
 	if item.Duration != 0 {
-		mem.Expiration = time.Now().Add(item.Duration).UnixNano()
+		mem.Expiration = c.clock.Now().Add(item.Duration).UnixNano()
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.Storage[key] = mem
+	c.watchers.notify(EventSet, key, c.clock.Now())
 	return nil
 }
 
@@ -143,8 +190,13 @@ func (c *Memory) GetOrSet(key string, result interface{}, create func() (*Item,
 	mu.Lock()
 	defer mu.Unlock()
 
+	c.mu.RLock()
 	entry, found := c.Storage[key]
-	if found && !entry.Expired(time.Now().UnixNano()) {
+	c.mu.RUnlock()
+
+	if now := c.clock.Now(); found && entry.Expired(now.UnixNano()) {
+		c.expire(key, now)
+	} else if found {
 		return json.Unmarshal(entry.Body, &result)
 	}
 
@@ -158,12 +210,13 @@ func (c *Memory) GetOrSet(key string, result interface{}, create func() (*Item,
 	}
 	mem := memoryItem{Body: body}
 	if item.Duration != 0 {
-		mem.Expiration = time.Now().Add(item.Duration).UnixNano()
+		mem.Expiration = c.clock.Now().Add(item.Duration).UnixNano()
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.Storage[key] = mem
+	c.watchers.notify(EventSet, key, c.clock.Now())
 
 	return json.Unmarshal(body, &result)
 }
@@ -171,5 +224,8 @@ func (c *Memory) GetOrSet(key string, result interface{}, create func() (*Item,
 func (c *Memory) Remove(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.Storage, key)
+	if _, found := c.Storage[key]; found {
+		delete(c.Storage, key)
+		c.watchers.notify(EventRemove, key, c.clock.Now())
+	}
 }
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var namespaceEscaper = strings.NewReplacer(`\`, `\\`, ":", `\:`)
+
+// Namespace is a view over a shared cache that stores every key under
+// "name:", so teams sharing one Redis DB or cache table cannot collide.
+// Colons and backslashes in name are escaped with a backslash, so a name
+// always ends at the first unescaped colon and namespace "a" can't reach
+// the keys of namespace "a:b".
+func NewNamespace(c Cache, name string) *Namespace {
+	return &Namespace{cache: c, name: name, prefix: namespaceEscaper.Replace(name) + ":"}
+}
+
+type NamespaceStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Sets    uint64 `json:"sets"`
+	Removes uint64 `json:"removes"`
+}
+
+type Namespace struct {
+	cache  Cache
+	name   string
+	prefix string
+
+	hits    uint64
+	misses  uint64
+	sets    uint64
+	removes uint64
+}
+
+func (c *Namespace) Name() string {
+	return c.name
+}
+
+func (c *Namespace) Stats() NamespaceStats {
+	return NamespaceStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Sets:    atomic.LoadUint64(&c.sets),
+		Removes: atomic.LoadUint64(&c.removes),
+	}
+}
+
+func (c *Namespace) LockRun(key string, d time.Duration, fn func() error) error {
+	return c.cache.LockRun(c.prefix+key, d, fn)
+}
+
+func (c *Namespace) Get(key string, result interface{}) error {
+	if err := c.cache.Get(c.prefix+key, result); err != nil {
+		atomic.AddUint64(&c.misses, 1)
+		return err
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return nil
+}
+
+func (c *Namespace) Set(key string, create func() (*Item, error)) error {
+	if err := c.cache.Set(c.prefix+key, create); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.sets, 1)
+	return nil
+}
+
+func (c *Namespace) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	created := false
+	err := c.cache.GetOrSet(c.prefix+key, result, func() (*Item, error) {
+		created = true
+		return create()
+	})
+	if created {
+		atomic.AddUint64(&c.misses, 1)
+		if err == nil {
+			atomic.AddUint64(&c.sets, 1)
+		}
+	} else if err == nil {
+		atomic.AddUint64(&c.hits, 1)
+	}
+	return err
+}
+
+func (c *Namespace) Remove(key string) {
+	c.cache.Remove(c.prefix + key)
+	atomic.AddUint64(&c.removes, 1)
+}
+
+func (c *Namespace) Keys(prefix string) ([]string, error) {
+	keys, err := listKeys(c.cache, c.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, c.prefix)
+	}
+	return keys, nil
+}
+
+// Clear removes every key of the namespace and leaves other keys untouched.
+func (c *Namespace) Clear() error {
+	keys, err := c.Keys("")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		c.Remove(k)
+	}
+	return nil
+}
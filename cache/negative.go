@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned by create functions to report that the value does
+// not exist at the source. Wrapped errors are recognised with errors.Is.
+var ErrNotFound = errors.New("cache: not found")
+
+type NotFoundError struct {
+	Key string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found", e.Key)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return ErrNotFound
+}
+
+// CachedError is a create error that was remembered by Negative and is being
+// replayed instead of calling create again.
+type CachedError struct {
+	Key     string
+	Message string
+}
+
+func (e *CachedError) Error() string {
+	return fmt.Sprintf("%s: %s (cached)", e.Key, e.Message)
+}
+
+type NegativeConfig struct {
+	// NotFoundDuration is how long a not-found result is remembered. Zero
+	// disables negative caching.
+	NotFoundDuration time.Duration
+	// ErrorDuration is how long any other create error is remembered. Zero
+	// disables error caching.
+	ErrorDuration time.Duration
+}
+
+// Negative remembers failed and empty loads in the wrapped cache, so a missing
+// row is only looked up once per NotFoundDuration instead of on every request.
+func NewNegative(c Cache, cfg *NegativeConfig) *Negative {
+	if cfg == nil {
+		cfg = &NegativeConfig{NotFoundDuration: time.Minute}
+	}
+	return &Negative{Cache: c, Config: cfg}
+}
+
+type Negative struct {
+	Cache
+	Config *NegativeConfig
+}
+
+type negativeItem struct {
+	Value    json.RawMessage `json:"value,omitempty"`
+	NotFound bool            `json:"notFound,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func (c *Negative) wrap(key string, create func() (*Item, error), createErr *error) func() (*Item, error) {
+	return func() (*Item, error) {
+		item, err := create()
+		switch {
+		case err == nil && item != nil:
+			body, err := json.Marshal(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			return &Item{Value: negativeItem{Value: body}, Duration: item.Duration}, nil
+		case err == nil || errors.Is(err, ErrNotFound):
+			if c.Config.NotFoundDuration <= 0 {
+				if err == nil {
+					err = ErrNotFound
+				}
+				return nil, err
+			}
+			*createErr = &NotFoundError{Key: key}
+			return &Item{Value: negativeItem{NotFound: true}, Duration: c.Config.NotFoundDuration}, nil
+		default:
+			if c.Config.ErrorDuration <= 0 {
+				return nil, err
+			}
+			*createErr = err
+			return &Item{Value: negativeItem{Error: err.Error()}, Duration: c.Config.ErrorDuration}, nil
+		}
+	}
+}
+
+func (c *Negative) unwrap(key string, entry negativeItem, result interface{}) error {
+	switch {
+	case entry.NotFound:
+		return &NotFoundError{Key: key}
+	case entry.Error != "":
+		return &CachedError{Key: key, Message: entry.Error}
+	}
+	return json.Unmarshal(entry.Value, result)
+}
+
+func (c *Negative) Keys(prefix string) ([]string, error) {
+	return listKeys(c.Cache, prefix)
+}
+
+func (c *Negative) Get(key string, result interface{}) error {
+	var entry negativeItem
+	if err := c.Cache.Get(key, &entry); err != nil {
+		return err
+	}
+	return c.unwrap(key, entry, result)
+}
+
+func (c *Negative) Set(key string, create func() (*Item, error)) error {
+	var createErr error
+	if err := c.Cache.Set(key, c.wrap(key, create, &createErr)); err != nil {
+		return err
+	}
+	return createErr
+}
+
+func (c *Negative) GetOrSet(key string, result interface{}, create func() (*Item, error)) error {
+	var (
+		entry     negativeItem
+		createErr error
+	)
+	if err := c.Cache.GetOrSet(key, &entry, c.wrap(key, create, &createErr)); err != nil {
+		return err
+	}
+	if createErr != nil {
+		return createErr
+	}
+	return c.unwrap(key, entry, result)
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
 type Redis struct {
 	*redis.Client
 	mu  sync.RWMutex
@@ -99,6 +103,60 @@ func (c *Redis) GetOrSet(key string, result interface{}, create func() (*Item, e
 	return json.Unmarshal(body, result)
 }
 
+func (c *Redis) Keys(prefix string) ([]string, error) {
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+	keys := make([]string, 0)
+	iter := c.Client.Scan(context.TODO(), 0, pattern, 1000).Iterator()
+	for iter.Next(context.TODO()) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// Watch relays Redis keyspace notifications, so it also sees writes made by
+// other clients. The server must have them enabled, for example with
+// "CONFIG SET notify-keyspace-events Kg$x". Redis globs differ from
+// path.Match, so it subscribes to every key sharing the pattern's literal
+// prefix and filters them with path.Match itself.
+func (c *Redis) Watch(pattern string) (<-chan Event, func()) {
+	prefix := fmt.Sprintf("__keyspace@%d__:", c.Client.Options().DB)
+	literal := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		literal = pattern[:i]
+	}
+	pubsub := c.Client.PSubscribe(context.TODO(), prefix+redisGlobEscaper.Replace(literal)+"*")
+	ch := make(chan Event, watchBuffer)
+	go func() {
+		defer close(ch)
+		for msg := range pubsub.Channel() {
+			event := Event{Key: strings.TrimPrefix(msg.Channel, prefix), Time: time.Now()}
+			if ok, _ := path.Match(pattern, event.Key); !ok {
+				continue
+			}
+			switch msg.Payload {
+			case "set":
+				event.Type = EventSet
+			case "del":
+				event.Type = EventRemove
+			case "expired":
+				event.Type = EventExpire
+			default:
+				continue
+			}
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			pubsub.Close()
+		})
+	}
+}
+
 func (c *Redis) Remove(key string) {
 	c.Client.Del(context.TODO(), key)
 }
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"path"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventSet    EventType = "SET"
+	EventRemove EventType = "REMOVE"
+	EventExpire EventType = "EXPIRE"
+)
+
+type Event struct {
+	Type EventType `json:"type"`
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+// Watcher is implemented by caches that can report key changes. Watch
+// returns the events for keys matching pattern (path.Match syntax) and a
+// function that stops the watch and closes the channel. Events are dropped
+// rather than blocking writers when the receiver falls behind.
+//
+// Memory, File and DB report an expiry when a read finds the expired key,
+// or otherwise at the next gc, every 10 minutes unless changed with ResetGC.
+// Disk reports it at the next compaction, see ResetCompact.
+type Watcher interface {
+	Watch(pattern string) (<-chan Event, func())
+}
+
+const watchBuffer = 64
+
+type watch struct {
+	pattern string
+	ch      chan Event
+}
+
+// watchers fans events out to the active watches. The zero value is ready
+// to use.
+type watchers struct {
+	mu      sync.RWMutex
+	watches map[*watch]struct{}
+}
+
+func (w *watchers) add(pattern string) (<-chan Event, func()) {
+	wt := &watch{pattern: pattern, ch: make(chan Event, watchBuffer)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watches == nil {
+		w.watches = make(map[*watch]struct{})
+	}
+	w.watches[wt] = struct{}{}
+
+	var once sync.Once
+	return wt.ch, func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			delete(w.watches, wt)
+			close(wt.ch)
+		})
+	}
+}
+
+func (w *watchers) notify(typ EventType, key string, now time.Time) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for wt := range w.watches {
+		if ok, _ := path.Match(wt.pattern, key); !ok {
+			continue
+		}
+		select {
+		case wt.ch <- Event{Type: typ, Key: key, Time: now}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package circuit_breaker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrUnknownBreaker = errors.New("circuit breaker: unknown breaker")
+
+func (c *Register) lookup(name string) (*Breaker, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	breaker, found := c.Breakers[name]
+	if !found {
+		return nil, ErrUnknownBreaker
+	}
+	return breaker, nil
+}
+
+func (c *Register) ForceOpen(name string) error {
+	breaker, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	breaker.ForceOpen()
+	return nil
+}
+
+func (c *Register) ForceClose(name string) error {
+	breaker, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	breaker.ForceClose()
+	return nil
+}
+
+func (c *Register) Disable(name string) error {
+	breaker, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	breaker.Disable()
+	return nil
+}
+
+func (c *Register) Reset(name string) error {
+	breaker, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	breaker.Reset()
+	return nil
+}
+
+type ConfigView struct {
+	Duration         string     `json:"duration"`
+	FailCounter      uint       `json:"failCounter"`
+	SuccessCounter   uint       `json:"successCounter"`
+	Window           WindowType `json:"window,omitempty"`
+	WindowSize       uint       `json:"windowSize,omitempty"`
+	WindowDuration   string     `json:"windowDuration,omitempty"`
+	FailureRate      float64    `json:"failureRate,omitempty"`
+	MinimumCalls     uint       `json:"minimumCalls,omitempty"`
+	HalfOpenMaxCalls uint       `json:"halfOpenMaxCalls,omitempty"`
+	SlowCallDuration string     `json:"slowCallDuration,omitempty"`
+	SlowCallRate     float64    `json:"slowCallRate,omitempty"`
+	Multiplier       float64    `json:"multiplier,omitempty"`
+	MaxDuration      string     `json:"maxDuration,omitempty"`
+	Jitter           float64    `json:"jitter,omitempty"`
+	Shared           bool       `json:"shared"`
+	Timeout          string     `json:"timeout,omitempty"`
+}
+
+type BreakerView struct {
+	Name string `json:"name"`
+	Snapshot
+	Config ConfigView `json:"config"`
+}
+
+func durationView(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func (c *Breaker) View() BreakerView {
+	snapshot := c.Snapshot()
+	cfg := c.config()
+	view := ConfigView{
+		Duration:         cfg.Duration.String(),
+		FailCounter:      cfg.FailCounter,
+		SuccessCounter:   cfg.SuccessCounter,
+		Window:           cfg.Window,
+		WindowSize:       cfg.WindowSize,
+		WindowDuration:   durationView(cfg.WindowDuration),
+		FailureRate:      cfg.FailureRate,
+		MinimumCalls:     cfg.MinimumCalls,
+		HalfOpenMaxCalls: cfg.HalfOpenMaxCalls,
+		SlowCallDuration: durationView(cfg.SlowCallDuration),
+		SlowCallRate:     cfg.SlowCallRate,
+		Multiplier:       cfg.Multiplier,
+		MaxDuration:      durationView(cfg.MaxDuration),
+		Jitter:           cfg.Jitter,
+		Shared:           c.shared(cfg),
+	}
+	if h, ok := cfg.Handler.(*TimeoutHandler); ok {
+		view.Timeout = durationView(h.Timeout)
+	}
+	return BreakerView{Name: c.Name, Snapshot: snapshot, Config: view}
+}
+
+// Views lists the breakers of the register sorted by name.
+func (c *Register) Views() []BreakerView {
+	c.mu.RLock()
+	breakers := make([]*Breaker, 0, len(c.Breakers))
+	for _, breaker := range c.Breakers {
+		breakers = append(breakers, breaker)
+	}
+	c.mu.RUnlock()
+	views := make([]BreakerView, 0, len(breakers))
+	for _, breaker := range breakers {
+		views = append(views, breaker.View())
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Name < views[j].Name
+	})
+	return views
+}
+
+// NewAdminHandler serves the breakers of r as JSON, relative to where it is
+// mounted (use http.StripPrefix):
+//
+//	GET  /                        all breakers
+//	GET  /{name}                  one breaker
+//	POST /{name}/force-open       ForceOpen
+//	POST /{name}/force-close      ForceClose
+//	POST /{name}/disable          Disable
+//	POST /{name}/reset            Reset
+//
+// Overrides apply to this process only. The handler does no authentication.
+func NewAdminHandler(r *Register) http.Handler {
+	return &adminHandler{register: r}
+}
+
+type adminHandler struct {
+	register *Register
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p := strings.Trim(req.URL.Path, "/")
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if p == "" {
+			writeJSON(w, http.StatusOK, h.register.Views())
+			return
+		}
+		breaker, err := h.register.lookup(p)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, breaker.View())
+	case http.MethodPost:
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown action"})
+			return
+		}
+		name, action := p[:i], p[i+1:]
+		breaker, err := h.register.lookup(name)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		switch action {
+		case "force-open":
+			breaker.ForceOpen()
+		case "force-close":
+			breaker.ForceClose()
+		case "disable":
+			breaker.Disable()
+		case "reset":
+			breaker.Reset()
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown action " + action})
+			return
+		}
+		writeJSON(w, http.StatusOK, breaker.View())
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,7 +1,11 @@
 package circuit_breaker
 
 import (
+	"context"
+	"errors"
+	"math"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -16,15 +20,35 @@ func New() *Breaker {
 			},
 			Handler: new(TimeoutHandler).SetTimeout(time.Second * 5),
 		},
+		State: StateClose,
 	}
 }
 
+var (
+	// ErrCircuitOpen is the fallback cause for calls rejected while open.
+	ErrCircuitOpen = errors.New("circuit breaker: circuit is open")
+	// ErrTooManyTrials is the fallback cause for calls rejected while
+	// half-open because every trial slot was taken.
+	ErrTooManyTrials = errors.New("circuit breaker: too many half-open trial calls")
+	// ErrCallFailed is the fallback cause for calls that ran and failed, see
+	// Config.FallbackOnFailure.
+	ErrCallFailed = errors.New("circuit breaker: call failed")
+)
+
 type State string
 
 const (
 	StateClose    State = "CLOSE"
 	StateHalfOpen State = "HALF_OPEN"
 	StateOpen     State = "OPEN"
+	// StateForcedOpen rejects every call until Reset.
+	StateForcedOpen State = "FORCED_OPEN"
+	// StateForcedClose admits every call through the Handler without
+	// counting outcomes, until Reset.
+	StateForcedClose State = "FORCED_CLOSE"
+	// StateDisabled calls fn directly, bypassing the Handler, fallbacks and
+	// events, until Reset.
+	StateDisabled State = "DISABLED"
 )
 
 type Config struct {
@@ -33,81 +57,597 @@ type Config struct {
 	SuccessCounter uint
 	Failback       func() (interface{}, error)
 	Handler        Handler
+
+	// Fallback, when set, replaces Failback. cause is ErrCircuitOpen,
+	// ErrTooManyTrials or ErrCallFailed; err is the error returned by the
+	// call, if it ran.
+	Fallback func(ctx context.Context, cause, err error) (interface{}, error)
+	// FallbackOnFailure also hands calls that ran and failed to the fallback.
+	FallbackOnFailure bool
+
+	// Multiplier, when above 1, grows the time spent open by that factor for
+	// every consecutive failed recovery. A successful close starts over
+	// from Duration. Jitter, a fraction such as 0.2, randomly lengthens or
+	// shortens each open interval by up to that much. No interval exceeds
+	// MaxDuration, if set.
+	Multiplier  float64
+	MaxDuration time.Duration
+	Jitter      float64
+
+	// Store shares the counts and the Open state with other processes, see
+	// Store. Only named breakers use it, and only while closed. The Open
+	// state is polled at most every StoreInterval, one second by default.
+	// When the Store errors the breaker emits EventStoreError and carries on
+	// with its local state alone for StoreInterval.
+	Store         Store
+	StoreInterval time.Duration
+
+	// Window selects how outcomes are counted while closed. WindowSize and
+	// WindowDuration size the COUNT and TIME windows.
+	Window         WindowType
+	WindowSize     uint
+	WindowDuration time.Duration
+	// FailureRate, a percentage, trips the breaker once that share of the
+	// counted calls failed. When zero, FailCounter failures trip it, unless
+	// FailCounter is zero too.
+	FailureRate float64
+	// MinimumCalls is the number of calls that must be counted before the
+	// breaker may trip. With a rate or MinimumCalls set, the default FIXED
+	// window only resets every Duration, not after SuccessCounter successes.
+	MinimumCalls uint
+	// HalfOpenMaxCalls caps the trial calls in flight while half-open; the
+	// rest get the fallback. Zero means one trial at a time. Only trial
+	// outcomes decide whether the breaker closes or reopens: with a
+	// FailureRate, once HalfOpenMaxCalls trials have finished, by their
+	// failure rate; otherwise once FailCounter trials failed or
+	// SuccessCounter trials succeeded, a zero counter counting as one.
+	HalfOpenMaxCalls uint
+
+	// By default every error returned by fn is a failure. IgnoreErrors are
+	// counted as neither success nor failure. Otherwise IsFailure decides,
+	// or, when it is nil and RecordErrors is set, only RecordErrors are
+	// failures. Errors are matched with errors.Is. Timeouts are always
+	// failures.
+	IsFailure    func(err error) bool
+	IgnoreErrors []error
+	RecordErrors []error
+
+	// SlowCallRate, a percentage, trips the breaker once that share of the
+	// counted calls took SlowCallDuration or longer, whether they failed or
+	// not. While half-open a slow trial counts as a failure. Slow calls are
+	// not shared through Store.
+	SlowCallDuration time.Duration
+	SlowCallRate     float64
+}
+
+func matchErrors(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
 }
 
+func (cfg *Config) fallback(ctx context.Context, cause, err error) (interface{}, error) {
+	switch {
+	case cfg.Fallback != nil:
+		return cfg.Fallback(ctx, cause, err)
+	case cfg.Failback != nil:
+		return cfg.Failback()
+	}
+	return nil, cause
+}
+
+// openInterval is how long the breaker stays open after reopens
+// consecutive failed recoveries.
+func (cfg *Config) openInterval(reopens uint) time.Duration {
+	d := float64(cfg.Duration)
+	if cfg.Multiplier > 1 {
+		d *= math.Pow(cfg.Multiplier, float64(reopens))
+	}
+	if cfg.Jitter > 0 {
+		d += d * cfg.Jitter * (rand.Float64()*2 - 1)
+	}
+	if cfg.MaxDuration > 0 && d > float64(cfg.MaxDuration) {
+		d = float64(cfg.MaxDuration)
+	}
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+func (cfg *Config) classify(err error) outcome {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case matchErrors(err, cfg.IgnoreErrors):
+		return outcomeIgnore
+	case cfg.IsFailure != nil:
+		if cfg.IsFailure(err) {
+			return outcomeFailure
+		}
+		return outcomeSuccess
+	case len(cfg.RecordErrors) > 0:
+		if matchErrors(err, cfg.RecordErrors) {
+			return outcomeFailure
+		}
+		return outcomeSuccess
+	}
+	return outcomeFailure
+}
+
+// Breaker is safe for concurrent use. Its exported counters are guarded by an
+// internal mutex; read them through Snapshot while calls are in flight.
+//
+// Every state change or window reset starts a new generation. A call records
+// its outcome only if the generation it started in is still current, so a
+// slow call that straddles a transition can't count towards the next state.
 type Breaker struct {
 	*Config
+	// Name identifies the breaker in its events. Register sets it.
+	Name           string
 	FailCounter    uint
 	SuccessCounter uint
+	SlowCounter    uint
 	State          State
 	Timestamp      int64
+
+	mu         sync.Mutex
+	generation uint64
+	window     window
+	trials     uint
+	// openFor is how long the current Open state lasts
+	openFor time.Duration
+	// reopens counts the failed recoveries since the breaker last closed
+	reopens uint
+	events  dispatcher
+
+	// polled is when the Store was last asked for the Open state, and
+	// polling is set while a call is asking it
+	polled  time.Time
+	polling bool
+	// storeDown is when the Store may be used again after an error
+	storeDown time.Time
+}
+
+type Snapshot struct {
+	State          State `json:"state"`
+	FailCounter    uint  `json:"failCounter"`
+	SuccessCounter uint  `json:"successCounter"`
+	SlowCounter    uint  `json:"slowCounter"`
+	Timestamp      int64 `json:"timestamp"`
+}
+
+func (c *Breaker) Snapshot() Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aswitch()
+	return Snapshot{
+		State:          c.state(),
+		FailCounter:    c.FailCounter,
+		SuccessCounter: c.SuccessCounter,
+		SlowCounter:    c.SlowCounter,
+		Timestamp:      c.Timestamp,
+	}
+}
+
+func (c *Breaker) state() State {
+	if c.State == "" {
+		return StateClose
+	}
+	return c.State
 }
 
 func (c *Breaker) init() {
+	c.generation++
+	c.trials = 0
+	if c.window != nil {
+		c.window.reset()
+	}
 	c.Timestamp = time.Now().UnixNano()
 	c.FailCounter = 0
 	c.SuccessCounter = 0
+	c.SlowCounter = 0
 }
 
 func (c *Breaker) duration() time.Duration {
 	return time.Duration(time.Now().UnixNano() - c.Timestamp)
 }
 
+// tripped reports whether the counted outcomes call for opening the breaker.
+func (c *Breaker) tripped() bool {
+	return c.trippedBy(c.FailCounter, c.SuccessCounter, c.SlowCounter)
+}
+
+func (c *Breaker) trippedBy(failures, successes, slow uint) bool {
+	total := failures + successes
+	if total == 0 || total < c.Config.MinimumCalls {
+		return false
+	}
+	if c.Config.SlowCallRate > 0 && float64(slow)*100 >= c.Config.SlowCallRate*float64(total) {
+		return true
+	}
+	if c.Config.FailureRate > 0 {
+		return float64(failures)*100 >= c.Config.FailureRate*float64(total)
+	}
+	return c.Config.FailCounter > 0 && failures >= c.Config.FailCounter
+}
+
+// rated reports whether the breaker trips on rates over a minimum number of
+// calls rather than on plain counts.
+func (cfg *Config) rated() bool {
+	return cfg.FailureRate > 0 || cfg.SlowCallRate > 0 || cfg.MinimumCalls > 0
+}
+
+// open moves the breaker to Open for d. The caller holds c.mu.
+func (c *Breaker) open(d time.Duration) {
+	c.init()
+	c.State = StateOpen
+	c.openFor = d
+}
+
+// trip opens the breaker on behalf of the Store. The caller holds c.mu.
+func (c *Breaker) trip(d time.Duration) {
+	from := c.state()
+	c.open(d)
+	c.emit(Event{Type: EventStateChange, From: from, To: StateOpen})
+}
+
+// aswitch moves the breaker to its next state. The caller holds c.mu.
 func (c *Breaker) aswitch() {
-	switch c.State {
+	from := c.state()
+	c.transition()
+	if to := c.state(); to != from {
+		c.emit(Event{Type: EventStateChange, From: from, To: to})
+	}
+}
+
+func (c *Breaker) transition() {
+	if c.window == nil {
+		c.window = newWindow(c.Config)
+	}
+	switch c.state() {
 	case StateOpen:
-		if c.duration() >= c.Config.Duration {
+		if c.openFor <= 0 {
+			c.openFor = c.Config.Duration
+		}
+		if c.duration() >= c.openFor {
 			c.init()
 			c.State = StateHalfOpen
 		}
 	case StateClose:
-		if c.duration() >= c.Config.Duration {
+		if c.window != nil {
+			c.FailCounter, c.SuccessCounter, c.SlowCounter = c.window.counts(time.Now().UnixNano())
+		} else if c.Config.Duration > 0 && c.duration() >= c.Config.Duration {
 			c.init()
 			return
 		}
-		fallthrough
-	case StateHalfOpen:
-		if c.FailCounter >= c.Config.FailCounter {
+		if c.tripped() {
+			c.open(c.Config.openInterval(c.reopens))
+			return
+		}
+		if c.window == nil && !c.Config.rated() && c.SuccessCounter >= c.Config.SuccessCounter {
 			c.init()
-			c.State = StateOpen
 		}
-		if c.SuccessCounter >= c.Config.SuccessCounter {
+	case StateHalfOpen:
+		settled, reopen := c.verdict()
+		switch {
+		case !settled:
+		case reopen:
+			c.reopens++
+			c.open(c.Config.openInterval(c.reopens))
+		default:
 			c.init()
 			c.State = StateClose
+			c.reopens = 0
 		}
 	}
 }
 
-func (c *Breaker) call(fn func() (interface{}, error)) (interface{}, error) {
-	ok, result, err := c.Config.Handler.Handle(c, fn)
-	if ok {
+func atLeastOne(n uint) uint {
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// verdict judges the finished half-open trials. It reports whether they
+// settle the state and, if so, whether the breaker must reopen.
+func (c *Breaker) verdict() (settled, reopen bool) {
+	failures, successes := c.FailCounter, c.SuccessCounter
+	if c.Config.FailureRate > 0 {
+		total := failures + successes
+		if total < atLeastOne(c.Config.HalfOpenMaxCalls) {
+			return false, false
+		}
+		return true, float64(failures)*100 >= c.Config.FailureRate*float64(total)
+	}
+	if failures >= atLeastOne(c.Config.FailCounter) {
+		return true, true
+	}
+	return successes >= atLeastOne(c.Config.SuccessCounter), false
+}
+
+// before admits a call. It returns the cause if the call must get the
+// fallback instead, either because the breaker is open or because the
+// half-open trial slots are taken.
+func (c *Breaker) before() (uint64, State, *Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aswitch()
+	state := c.state()
+	switch state {
+	case StateOpen, StateForcedOpen:
+		return c.generation, state, c.Config, ErrCircuitOpen
+	case StateHalfOpen:
+		if c.trials >= atLeastOne(c.Config.HalfOpenMaxCalls) {
+			return c.generation, state, c.Config, ErrTooManyTrials
+		}
+		c.trials++
+	}
+	return c.generation, state, c.Config, nil
+}
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnore frees the call's trial slot without counting it
+	outcomeIgnore
+)
+
+// shared holds the counts a Store returned for a call.
+type shared struct {
+	failures  uint
+	successes uint
+}
+
+// after records the outcome of a call and, if the call opened the breaker,
+// returns for how long.
+func (c *Breaker) after(generation uint64, o outcome, slow bool, counts *shared) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return 0, false
+	}
+	from := c.state()
+	if from == StateHalfOpen {
+		c.trials--
+	}
+	if from != StateClose && from != StateHalfOpen {
+		return 0, false
+	}
+	switch {
+	case o == outcomeIgnore:
+		return 0, false
+	case c.window != nil && c.state() == StateClose:
+		c.window.record(time.Now().UnixNano(), o == outcomeFailure, slow)
+	case from == StateHalfOpen && slow && c.Config.SlowCallRate > 0:
+		c.FailCounter++
+	case o == outcomeSuccess:
 		c.SuccessCounter++
-	} else {
+	default:
 		c.FailCounter++
 	}
+	if slow && c.window == nil && from == StateClose {
+		c.SlowCounter++
+	}
 	c.aswitch()
-	return result, err
+	if counts != nil && c.state() == StateClose && c.trippedBy(counts.failures, counts.successes, 0) {
+		c.trip(c.Config.openInterval(c.reopens))
+	}
+	return c.openFor, from != StateOpen && c.state() == StateOpen
+}
+
+func (c *Breaker) shared(cfg *Config) bool {
+	return cfg.Store != nil && c.Name != ""
+}
+
+func (cfg *Config) storeInterval() time.Duration {
+	if cfg.StoreInterval > 0 {
+		return cfg.StoreInterval
+	}
+	return time.Second
+}
+
+// storeUp reports whether the Store may be used. The caller holds c.mu.
+func (c *Breaker) storeUp() bool {
+	return !time.Now().Before(c.storeDown)
+}
+
+func (c *Breaker) storeFailed(cfg *Config, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.storeDown = time.Now().Add(cfg.storeInterval())
+	c.emit(Event{Type: EventStoreError, Err: err})
+}
+
+// pull opens the breaker if another process sharing cfg.Store opened it.
+// Only closed breakers look; half-open ones run their trials regardless.
+func (c *Breaker) pull(ctx context.Context, cfg *Config) {
+	c.mu.Lock()
+	if c.state() != StateClose || !c.storeUp() || c.polling || time.Since(c.polled) < cfg.storeInterval() {
+		c.mu.Unlock()
+		return
+	}
+	c.polling = true
+	c.mu.Unlock()
+
+	until, err := cfg.Store.OpenUntil(ctx, c.Name)
+
+	c.mu.Lock()
+	c.polling = false
+	c.polled = time.Now()
+	c.mu.Unlock()
+	if err != nil {
+		c.storeFailed(cfg, err)
+		return
+	}
+	if !until.After(time.Now()) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state() == StateClose {
+		c.trip(time.Until(until))
+	}
 }
 
-func (c *Breaker) attemptCall(fn func() (interface{}, error)) (interface{}, error) {
-	if rand.New(rand.NewSource(time.Now().UnixNano())).Intn(10) >= 5 {
-		return c.call(fn)
+// push adds an outcome to the counts in cfg.Store, returning nil if the
+// Store is unavailable.
+func (c *Breaker) push(cfg *Config, o outcome) *shared {
+	c.mu.Lock()
+	up := c.storeUp()
+	c.mu.Unlock()
+	if !up {
+		return nil
+	}
+	d := cfg.WindowDuration
+	if d <= 0 {
+		d = cfg.Duration
+	}
+	failures, successes, err := cfg.Store.Record(context.Background(), c.Name, o == outcomeFailure, d)
+	if err != nil {
+		c.storeFailed(cfg, err)
+		return nil
 	}
-	return c.Config.Failback()
+	return &shared{failures: failures, successes: successes}
+}
+
+// publish tells the processes sharing cfg.Store that the breaker opened
+// for d.
+func (c *Breaker) publish(cfg *Config, d time.Duration) {
+	c.mu.Lock()
+	up := c.storeUp()
+	c.mu.Unlock()
+	if !up {
+		return
+	}
+	if err := cfg.Store.Open(context.Background(), c.Name, d); err != nil {
+		c.storeFailed(cfg, err)
+	}
+}
+
+// force puts the breaker in state to, dropping its counts. The caller
+// holds c.mu.
+func (c *Breaker) force(to State) {
+	from := c.state()
+	c.init()
+	c.reopens = 0
+	c.State = to
+	if to != from {
+		c.emit(Event{Type: EventStateChange, From: from, To: to})
+	}
+}
+
+// ForceOpen rejects every call until Reset.
+func (c *Breaker) ForceOpen() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.force(StateForcedOpen)
+}
+
+// ForceClose admits every call until Reset, without counting outcomes.
+func (c *Breaker) ForceClose() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.force(StateForcedClose)
+}
+
+// Disable bypasses the breaker until Reset.
+func (c *Breaker) Disable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.force(StateDisabled)
+}
+
+// Reset lifts any override and closes the breaker with empty counts.
+func (c *Breaker) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.force(StateClose)
+}
+
+func (c *Breaker) config() *Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Config
 }
 
 func (c *Breaker) SetConfig(cfg *Config) *Breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Config = cfg
+	c.window = nil
 	return c
 }
 
 func (c *Breaker) Run(fn func() (interface{}, error)) (interface{}, error) {
-	c.aswitch()
-	if c.State == StateOpen {
-		return c.Failback()
+	return c.RunContext(context.Background(), func(context.Context) (interface{}, error) {
+		return fn()
+	})
+}
+
+// RunContext passes ctx on to fn through the Handler. A call abandoned
+// because ctx was cancelled by the caller is not held against the
+// dependency; a call cut short by the Handler's own timeout is.
+func (c *Breaker) RunContext(ctx context.Context, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	return c.run(ctx, fn, nil)
+}
+
+// run is RunContext with an optional fallback that takes the place of
+// cfg.fallback, given the Config the call ran with.
+func (c *Breaker) run(ctx context.Context, fn func(context.Context) (interface{}, error), fallback func(*Config, context.Context, error, error) (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cfg := c.config(); c.shared(cfg) {
+		c.pull(ctx, cfg)
 	}
-	if c.State == StateHalfOpen {
-		return c.attemptCall(fn)
+	generation, state, cfg, cause := c.before()
+	if state == StateDisabled {
+		return fn(ctx)
 	}
-	return c.call(fn)
+	if fallback == nil {
+		fallback = (*Config).fallback
+	}
+	if cause != nil {
+		c.emit(Event{Type: EventReject, Err: cause})
+		return fallback(cfg, ctx, cause, nil)
+	}
+	// a panicking fn counts as a failure, so it can't hold a trial slot
+	o := outcomeFailure
+	var err error
+	start := time.Now()
+	defer func() {
+		latency := time.Since(start)
+		slow := cfg.SlowCallDuration > 0 && latency >= cfg.SlowCallDuration
+		switch o {
+		case outcomeSuccess:
+			c.emit(Event{Type: EventSuccess, Latency: latency, Err: err})
+		case outcomeFailure:
+			c.emit(Event{Type: EventFailure, Latency: latency, Err: err})
+		}
+		var counts *shared
+		if state == StateClose && o != outcomeIgnore && c.shared(cfg) {
+			counts = c.push(cfg, o)
+		}
+		if d, opened := c.after(generation, o, slow, counts); opened && c.shared(cfg) {
+			c.publish(cfg, d)
+		}
+	}()
+	ok, result, err := cfg.Handler.Handle(ctx, c, fn)
+	switch {
+	case ok:
+		o = cfg.classify(err)
+	case ctx.Err() != nil:
+		o = outcomeIgnore
+	}
+	if o == outcomeFailure && cfg.FallbackOnFailure {
+		return fallback(cfg, ctx, ErrCallFailed, err)
+	}
+	return result, err
 }
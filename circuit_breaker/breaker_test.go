@@ -0,0 +1,200 @@
+package circuit_breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+func succeed() (interface{}, error) {
+	return "ok", nil
+}
+
+func fail() (interface{}, error) {
+	return nil, errBoom
+}
+
+func TestRunConcurrentCounts(t *testing.T) {
+	b := New().SetConfig(&Config{
+		Duration:       time.Hour,
+		FailCounter:    1 << 20,
+		SuccessCounter: 1 << 20,
+		Handler:        &TimeoutHandler{},
+	})
+	const goroutines, calls = 50, 200
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				fn := succeed
+				if (g+i)%2 == 0 {
+					fn = fail
+				}
+				b.Run(fn)
+				if i%10 == 0 {
+					b.Snapshot()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	s := b.Snapshot()
+	if s.State != StateClose {
+		t.Fatalf("state = %s, want %s", s.State, StateClose)
+	}
+	if want := uint(goroutines * calls / 2); s.FailCounter != want || s.SuccessCounter != want {
+		t.Fatalf("counters = %d failures, %d successes, want %d each", s.FailCounter, s.SuccessCounter, want)
+	}
+}
+
+func TestRunConcurrentTripAndRecover(t *testing.T) {
+	b := New().SetConfig(&Config{
+		Duration:       200 * time.Millisecond,
+		FailCounter:    5,
+		SuccessCounter: 2,
+		Handler:        &TimeoutHandler{},
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				b.Run(fail)
+				b.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	if s := b.Snapshot(); s.State != StateOpen {
+		t.Fatalf("state after failures = %s, want %s", s.State, StateOpen)
+	}
+	if _, err := b.Run(succeed); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open breaker returned %v, want %v", err, ErrCircuitOpen)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if s := b.Snapshot(); s.State != StateHalfOpen {
+		t.Fatalf("state after Duration = %s, want %s", s.State, StateHalfOpen)
+	}
+
+	// concurrent trials: one slot, so the rest get the fallback
+	for round := 0; round < 2; round++ {
+		var trials sync.WaitGroup
+		for g := 0; g < 20; g++ {
+			trials.Add(1)
+			go func() {
+				defer trials.Done()
+				b.Run(succeed)
+			}()
+		}
+		trials.Wait()
+	}
+	if s := b.Snapshot(); s.State != StateClose {
+		t.Fatalf("state after successful trials = %s, want %s", s.State, StateClose)
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	r := NewRegister()
+	const names = 10
+
+	var mu sync.Mutex
+	seen := make(map[string]*Breaker)
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				name := fmt.Sprint("breaker-", (g+i)%names)
+				b := r.Register(name, nil)
+				b.Run(succeed)
+
+				mu.Lock()
+				if prev, ok := seen[name]; ok && prev != b {
+					t.Errorf("Register(%q) returned two breakers", name)
+				}
+				seen[name] = b
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if len(r.Views()) != names {
+		t.Fatalf("register holds %d breakers, want %d", len(r.Views()), names)
+	}
+}
+
+func TestFailureRateFixedWindow(t *testing.T) {
+	b := New().SetConfig(&Config{
+		Duration:     time.Hour,
+		FailureRate:  50,
+		MinimumCalls: 10,
+		Handler:      &TimeoutHandler{},
+	})
+	for i := 0; i < 9; i++ {
+		b.Run(fail)
+	}
+	if s := b.Snapshot(); s.State != StateClose || s.FailCounter != 9 {
+		t.Fatalf("after 9 failures: %+v, want CLOSE with 9 failures counted", s)
+	}
+	b.Run(fail)
+	if s := b.Snapshot(); s.State != StateOpen {
+		t.Fatalf("state after 10 failures = %s, want %s", s.State, StateOpen)
+	}
+
+	// the SuccessCounter reset of the plain fixed window must not wipe the
+	// counts before MinimumCalls is reached
+	b = New().SetConfig(&Config{
+		Duration:       time.Hour,
+		SuccessCounter: 10,
+		FailureRate:    30,
+		MinimumCalls:   20,
+		Handler:        &TimeoutHandler{},
+	})
+	for i := 0; i < 30 && b.Snapshot().State == StateClose; i++ {
+		if i%3 == 0 {
+			b.Run(fail)
+		} else {
+			b.Run(succeed)
+		}
+	}
+	if s := b.Snapshot(); s.State != StateOpen {
+		t.Fatalf("state at a 33%% failure rate = %s, want %s", s.State, StateOpen)
+	}
+}
+
+func TestSlowCallRateFixedWindow(t *testing.T) {
+	b := New().SetConfig(&Config{
+		Duration:         time.Hour,
+		SlowCallDuration: 5 * time.Millisecond,
+		SlowCallRate:     50,
+		MinimumCalls:     20,
+		Handler:          &TimeoutHandler{},
+	})
+	slow := func() (interface{}, error) {
+		time.Sleep(6 * time.Millisecond)
+		return "ok", nil
+	}
+	for i := 0; i < 19; i++ {
+		b.Run(slow)
+	}
+	if s := b.Snapshot(); s.State != StateClose || s.SlowCounter != 19 {
+		t.Fatalf("after 19 slow calls: %+v, want CLOSE with 19 slow calls counted", s)
+	}
+	b.Run(slow)
+	if s := b.Snapshot(); s.State != StateOpen {
+		t.Fatalf("state after 20 slow successes = %s, want %s", s.State, StateOpen)
+	}
+}
@@ -0,0 +1,228 @@
+package circuit_breaker
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventStateChange EventType = "STATE_CHANGE"
+	EventReject      EventType = "REJECT"
+	EventSuccess     EventType = "SUCCESS"
+	EventFailure     EventType = "FAILURE"
+	// EventStoreError reports a failed Config.Store operation in Err.
+	EventStoreError EventType = "STORE_ERROR"
+)
+
+type Event struct {
+	Name    string
+	Type    EventType
+	From    State
+	To      State
+	Latency time.Duration
+	Err     error
+	Time    time.Time
+}
+
+const eventBuffer = 1024
+
+type listener struct {
+	fn func(Event)
+	// types lists the events fn wants; empty means all of them
+	types []EventType
+}
+
+func (l listener) wants(t EventType) bool {
+	if len(l.types) == 0 {
+		return true
+	}
+	for _, want := range l.types {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatcher hands events to listeners on its own goroutine, so a slow
+// listener never holds up a call. Only event types someone listens for are
+// queued. State changes are never dropped; the other events go through a
+// buffer of eventBuffer and are dropped and counted when listeners fall that
+// far behind. The zero value is ready to use.
+type dispatcher struct {
+	mu        sync.RWMutex
+	listeners []listener
+	wanted    map[EventType]bool
+	all       bool
+
+	once    sync.Once
+	calls   chan Event
+	notify  chan struct{}
+	qmu     sync.Mutex
+	changes []Event
+	dropped uint64
+}
+
+func (d *dispatcher) subscribe(l listener) {
+	d.once.Do(func() {
+		d.calls = make(chan Event, eventBuffer)
+		d.notify = make(chan struct{}, 1)
+		go d.loop()
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.listeners = append(d.listeners, l)
+	if len(l.types) == 0 {
+		d.all = true
+	}
+	if d.wanted == nil {
+		d.wanted = make(map[EventType]bool)
+	}
+	for _, t := range l.types {
+		d.wanted[t] = true
+	}
+}
+
+func (d *dispatcher) emit(e Event) {
+	d.mu.RLock()
+	wanted := d.all || d.wanted[e.Type]
+	d.mu.RUnlock()
+	if !wanted {
+		return
+	}
+	if e.Type == EventStateChange {
+		d.qmu.Lock()
+		d.changes = append(d.changes, e)
+		d.qmu.Unlock()
+		select {
+		case d.notify <- struct{}{}:
+		default:
+		}
+		return
+	}
+	select {
+	case d.calls <- e:
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+	}
+}
+
+func (d *dispatcher) loop() {
+	for {
+		select {
+		case <-d.notify:
+		case e := <-d.calls:
+			d.deliver(e)
+		}
+		d.qmu.Lock()
+		changes := d.changes
+		d.changes = nil
+		d.qmu.Unlock()
+		for _, e := range changes {
+			d.deliver(e)
+		}
+	}
+}
+
+func (d *dispatcher) deliver(e Event) {
+	d.mu.RLock()
+	listeners := d.listeners
+	d.mu.RUnlock()
+	for _, l := range listeners {
+		if l.wants(e.Type) {
+			d.call(l.fn, e)
+		}
+	}
+}
+
+func (d *dispatcher) call(fn func(Event), e Event) {
+	defer func() {
+		if x := recover(); x != nil {
+			log.Println("circuit breaker listener:", x)
+		}
+	}()
+	fn(e)
+}
+
+// Subscribe registers fn for the given event types of the breaker, or for
+// all of them when none are given.
+func (c *Breaker) Subscribe(fn func(Event), types ...EventType) {
+	c.events.subscribe(listener{fn: fn, types: types})
+}
+
+// DroppedEvents is the number of events discarded because listeners fell
+// behind. State changes are never discarded.
+func (c *Breaker) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&c.events.dropped)
+}
+
+func (c *Breaker) OnStateChange(fn func(name string, from, to State)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name, e.From, e.To)
+	}, EventStateChange)
+}
+
+func (c *Breaker) OnReject(fn func(name string)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name)
+	}, EventReject)
+}
+
+func (c *Breaker) OnSuccess(fn func(name string, latency time.Duration)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name, e.Latency)
+	}, EventSuccess)
+}
+
+func (c *Breaker) OnFailure(fn func(name string, latency time.Duration, err error)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name, e.Latency, e.Err)
+	}, EventFailure)
+}
+
+func (c *Breaker) emit(e Event) {
+	e.Name = c.Name
+	e.Time = time.Now()
+	c.events.emit(e)
+}
+
+// Subscribe registers fn for the given event types of every breaker in the
+// register, including breakers registered later, or for all of them when
+// none are given.
+func (c *Register) Subscribe(fn func(Event), types ...EventType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := listener{fn: fn, types: types}
+	c.listeners = append(c.listeners, l)
+	for _, breaker := range c.Breakers {
+		breaker.events.subscribe(l)
+	}
+}
+
+func (c *Register) OnStateChange(fn func(name string, from, to State)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name, e.From, e.To)
+	}, EventStateChange)
+}
+
+func (c *Register) OnReject(fn func(name string)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name)
+	}, EventReject)
+}
+
+func (c *Register) OnSuccess(fn func(name string, latency time.Duration)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name, e.Latency)
+	}, EventSuccess)
+}
+
+func (c *Register) OnFailure(fn func(name string, latency time.Duration, err error)) {
+	c.Subscribe(func(e Event) {
+		fn(e.Name, e.Latency, e.Err)
+	}, EventFailure)
+}
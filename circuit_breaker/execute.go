@@ -0,0 +1,58 @@
+package circuit_breaker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// FallbackTypeError is returned by Execute when the configured fallback,
+// which isn't typed, returns something other than a T without an error,
+// including nil. It unwraps to the cause the fallback was called for, so
+// errors.Is(err, ErrCircuitOpen) still holds.
+type FallbackTypeError struct {
+	Cause error
+	// Err is the error of the call, if it ran
+	Err   error
+	Value interface{}
+	Want  string
+}
+
+func (e *FallbackTypeError) Error() string {
+	return fmt.Sprintf("circuit breaker: fallback returned %T, want %s: %v", e.Value, e.Want, e.Cause)
+}
+
+func (e *FallbackTypeError) Unwrap() error {
+	return e.Cause
+}
+
+// Execute runs fn through b like RunContext, without the type assertions.
+// See FallbackTypeError for configured fallbacks that don't return a T.
+func Execute[T any](b *Breaker, ctx context.Context, fn func(context.Context) (T, error)) (T, error) {
+	return ExecuteFallback(b, ctx, fn, nil)
+}
+
+// ExecuteFallback is Execute with a typed fallback that takes the place of
+// the configured one for this call. A nil fallback uses the configured one.
+func ExecuteFallback[T any](b *Breaker, ctx context.Context, fn func(context.Context) (T, error), fallback func(ctx context.Context, cause, err error) (T, error)) (T, error) {
+	fb := func(cfg *Config, ctx context.Context, cause, err error) (interface{}, error) {
+		if fallback != nil {
+			return fallback(ctx, cause, err)
+		}
+		result, ferr := cfg.fallback(ctx, cause, err)
+		if _, ok := result.(T); !ok && ferr == nil {
+			return nil, &FallbackTypeError{
+				Cause: cause,
+				Err:   err,
+				Value: result,
+				Want:  reflect.TypeOf((*T)(nil)).Elem().String(),
+			}
+		}
+		return result, ferr
+	}
+	result, err := b.run(ctx, func(ctx context.Context) (interface{}, error) {
+		return fn(ctx)
+	}, fb)
+	v, _ := result.(T)
+	return v, err
+}
@@ -1,9 +1,28 @@
 package circuit_breaker
 
-import "time"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrTimeout = errors.New("circuit breaker: call timed out")
+
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("circuit breaker: call didn't finish within %s", e.Timeout)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
 
 type Handler interface {
-	Handle(*Breaker, func() (interface{}, error)) (bool, interface{}, error)
+	Handle(context.Context, *Breaker, func(context.Context) (interface{}, error)) (bool, interface{}, error)
 }
 
 type TimeoutHandler struct {
@@ -15,12 +34,45 @@ func (h *TimeoutHandler) SetTimeout(d time.Duration) *TimeoutHandler {
 	return h
 }
 
-func (h *TimeoutHandler) Handle(c *Breaker, fn func() (interface{}, error)) (bool, interface{}, error) {
-	if h.Timeout == 0 {
-		h.Timeout = time.Second * 5
+type handlerResult struct {
+	result interface{}
+	err    error
+	panic  interface{}
+}
+
+// Handle runs fn with a context that is cancelled after Timeout and returns
+// a *TimeoutError as soon as the timeout passes, without waiting for fn. fn
+// is expected to give up once its context is done.
+func (h *TimeoutHandler) Handle(ctx context.Context, c *Breaker, fn func(context.Context) (interface{}, error)) (bool, interface{}, error) {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = time.Second * 5
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	done := make(chan handlerResult, 1)
+	go func() {
+		defer func() {
+			if x := recover(); x != nil {
+				done <- handlerResult{panic: x}
+			}
+		}()
+		result, err := fn(ctx)
+		done <- handlerResult{result: result, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.panic != nil {
+			panic(r.panic)
+		}
+		return ctx.Err() == nil, r.result, r.err
+	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			return false, nil, err
+		}
+		return false, nil, &TimeoutError{Timeout: timeout}
 	}
-	before := time.Now()
-	result, err := fn()
-	after := time.Now()
-	return after.Before(before.Add(h.Timeout)), result, err
 }
@@ -14,7 +14,8 @@ func NewRegister() *Register {
 type Register struct {
 	Breakers map[string]*Breaker
 
-	mu sync.RWMutex
+	mu        sync.RWMutex
+	listeners []listener
 }
 
 func (c *Register) Get(name string) *Breaker {
@@ -22,14 +23,25 @@ func (c *Register) Get(name string) *Breaker {
 }
 
 func (c *Register) Register(name string, cfg *Config) *Breaker {
-	c.mu.Lock()
-	defer c.mu.RLock()
+	c.mu.RLock()
 	breaker, found := c.Breakers[name]
-	if !found {
-		breaker = New()
-		if cfg != nil {
-			breaker.SetConfig(cfg)
-		}
+	c.mu.RUnlock()
+	if found {
+		return breaker
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if breaker, found := c.Breakers[name]; found {
+		return breaker
+	}
+	breaker = New()
+	breaker.Name = name
+	if cfg != nil {
+		breaker.SetConfig(cfg)
+	}
+	for _, l := range c.listeners {
+		breaker.events.subscribe(l)
 	}
 	c.Breakers[name] = breaker
 	return breaker
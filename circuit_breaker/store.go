@@ -0,0 +1,166 @@
+package circuit_breaker
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Store shares breaker state between processes. Breakers with the same Name
+// and Store add their outcomes to common counts while closed, and all of
+// them open once any of them trips. Half-open trials stay local.
+type Store interface {
+	// Record adds an outcome to the counts of name and returns the counts of
+	// the last window.
+	Record(ctx context.Context, name string, failure bool, window time.Duration) (failures, successes uint, err error)
+	// Open opens name for d and drops its counts.
+	Open(ctx context.Context, name string, d time.Duration) error
+	// OpenUntil returns when name stops being open, or the zero time if it
+	// isn't open.
+	OpenUntil(ctx context.Context, name string) (time.Time, error)
+}
+
+// NewMemoryStore shares state between the breakers of one process, for
+// example those of several Registers, or stands in for a RedisStore in
+// tests and single-instance deployments.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	until  time.Time
+	window time.Duration
+	counts *timeWindow
+}
+
+func (s *MemoryStore) entry(name string) *memoryEntry {
+	e, found := s.entries[name]
+	if !found {
+		e = &memoryEntry{}
+		s.entries[name] = e
+	}
+	return e
+}
+
+func (s *MemoryStore) Record(ctx context.Context, name string, failure bool, window time.Duration) (uint, uint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entry(name)
+	if e.counts == nil || e.window != window {
+		e.window, e.counts = window, newTimeWindow(window)
+	}
+	now := time.Now().UnixNano()
+	e.counts.record(now, failure, false)
+	failures, successes, _ := e.counts.counts(now)
+	return failures, successes, nil
+}
+
+func (s *MemoryStore) Open(ctx context.Context, name string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entry(name)
+	e.until = time.Now().Add(d)
+	if e.counts != nil {
+		e.counts.reset()
+	}
+	return nil
+}
+
+func (s *MemoryStore) OpenUntil(ctx context.Context, name string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, found := s.entries[name]
+	if !found || !e.until.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return e.until, nil
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{
+		Client: client,
+		Prefix: "circuit_breaker:",
+	}
+}
+
+// RedisStore keeps the counts of each breaker in a hash of windowBuckets
+// time buckets, so they age out like a TIME window.
+type RedisStore struct {
+	Client redis.UniversalClient
+	Prefix string
+}
+
+func (s *RedisStore) Record(ctx context.Context, name string, failure bool, window time.Duration) (uint, uint, error) {
+	width := int64(window) / windowBuckets
+	if width < int64(time.Millisecond) {
+		width = int64(time.Millisecond)
+	}
+	current := time.Now().UnixNano() / width
+	field := strconv.FormatInt(current, 10) + ":s"
+	if failure {
+		field = strconv.FormatInt(current, 10) + ":f"
+	}
+	key := s.Prefix + name + ":counts"
+
+	pipe := s.Client.TxPipeline()
+	pipe.HIncrBy(ctx, key, field, 1)
+	pipe.PExpire(ctx, key, time.Duration(width*(windowBuckets+1)))
+	all := pipe.HGetAll(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	var failures, successes uint
+	var stale []string
+	for f, v := range all.Val() {
+		i := strings.IndexByte(f, ':')
+		if i < 0 {
+			stale = append(stale, f)
+			continue
+		}
+		bucket, err := strconv.ParseInt(f[:i], 10, 64)
+		if err != nil || bucket <= current-windowBuckets {
+			stale = append(stale, f)
+			continue
+		}
+		n, _ := strconv.ParseUint(v, 10, 64)
+		if f[i+1:] == "f" {
+			failures += uint(n)
+		} else {
+			successes += uint(n)
+		}
+	}
+	if len(stale) > 0 {
+		s.Client.HDel(ctx, key, stale...)
+	}
+	return failures, successes, nil
+}
+
+func (s *RedisStore) Open(ctx context.Context, name string, d time.Duration) error {
+	until := time.Now().Add(d).UnixNano()
+	pipe := s.Client.TxPipeline()
+	pipe.Set(ctx, s.Prefix+name+":open", until, d)
+	pipe.Del(ctx, s.Prefix+name+":counts")
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) OpenUntil(ctx context.Context, name string) (time.Time, error) {
+	until, err := s.Client.Get(ctx, s.Prefix+name+":open").Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, until), nil
+}
@@ -0,0 +1,147 @@
+package circuit_breaker
+
+import "time"
+
+type WindowType string
+
+const (
+	// WindowFixed counts outcomes in consecutive Config.Duration buckets that
+	// reset abruptly. It is the default.
+	WindowFixed WindowType = "FIXED"
+	// WindowCount keeps the outcomes of the last Config.WindowSize calls.
+	WindowCount WindowType = "COUNT"
+	// WindowTime keeps the outcomes of the calls made in the last
+	// Config.WindowDuration, aggregated in windowBuckets buckets.
+	WindowTime WindowType = "TIME"
+)
+
+const windowBuckets = 10
+
+type window interface {
+	record(now int64, failure, slow bool)
+	counts(now int64) (failures, successes, slow uint)
+	reset()
+}
+
+func newWindow(cfg *Config) window {
+	switch cfg.Window {
+	case WindowCount:
+		size := cfg.WindowSize
+		if size == 0 {
+			size = 100
+		}
+		return &countWindow{outcomes: make([]mark, size)}
+	case WindowTime:
+		d := cfg.WindowDuration
+		if d <= 0 {
+			d = cfg.Duration
+		}
+		return newTimeWindow(d)
+	}
+	return nil
+}
+
+func newTimeWindow(d time.Duration) *timeWindow {
+	width := int64(d) / windowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &timeWindow{width: width, buckets: make([]timeBucket, windowBuckets)}
+}
+
+type mark struct {
+	failure bool
+	slow    bool
+}
+
+// countWindow is a ring buffer of the last len(outcomes) outcomes.
+type countWindow struct {
+	outcomes []mark
+	next     int
+	size     int
+	failures uint
+	slow     uint
+}
+
+func (w *countWindow) record(now int64, failure, slow bool) {
+	if w.size == len(w.outcomes) {
+		old := w.outcomes[w.next]
+		if old.failure {
+			w.failures--
+		}
+		if old.slow {
+			w.slow--
+		}
+	} else {
+		w.size++
+	}
+	w.outcomes[w.next] = mark{failure: failure, slow: slow}
+	if failure {
+		w.failures++
+	}
+	if slow {
+		w.slow++
+	}
+	w.next = (w.next + 1) % len(w.outcomes)
+}
+
+func (w *countWindow) counts(now int64) (uint, uint, uint) {
+	return w.failures, uint(w.size) - w.failures, w.slow
+}
+
+func (w *countWindow) reset() {
+	w.next, w.size, w.failures, w.slow = 0, 0, 0, 0
+}
+
+type timeBucket struct {
+	start     int64
+	failures  uint
+	successes uint
+	slow      uint
+}
+
+// timeWindow spreads outcomes over buckets of width nanoseconds, so old
+// outcomes age out one bucket at a time instead of all at once.
+type timeWindow struct {
+	width   int64
+	buckets []timeBucket
+}
+
+func (w *timeWindow) bucket(now int64) *timeBucket {
+	start := now - now%w.width
+	b := &w.buckets[(start/w.width)%int64(len(w.buckets))]
+	if b.start != start {
+		*b = timeBucket{start: start}
+	}
+	return b
+}
+
+func (w *timeWindow) record(now int64, failure, slow bool) {
+	b := w.bucket(now)
+	if failure {
+		b.failures++
+	} else {
+		b.successes++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+func (w *timeWindow) counts(now int64) (failures, successes, slow uint) {
+	oldest := now - now%w.width - w.width*int64(len(w.buckets)-1)
+	for _, b := range w.buckets {
+		if b.start >= oldest && b.start <= now {
+			failures += b.failures
+			successes += b.successes
+			slow += b.slow
+		}
+	}
+	return
+}
+
+func (w *timeWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = timeBucket{}
+	}
+}
@@ -1,9 +1,16 @@
 module github.com/xxiss/gotools
 
-go 1.16
+go 1.18
 
 require (
-	github.com/go-redis/redis/v8 v8.11.4 // indirect
-	golang.org/x/text v0.3.7 // indirect
-	gorm.io/gorm v1.22.4 // indirect
+	github.com/go-redis/redis/v8 v8.11.4
+	golang.org/x/text v0.3.7
+	gorm.io/gorm v1.22.4
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.3 // indirect
 )